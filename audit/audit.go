@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const ActionImpersonationStart string = "impersonation.start"
+const ActionImpersonatedRequest string = "impersonation.request"
+
+// Entry is one line of the audit log: Actor did Action as Subject.
+type Entry struct {
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	Subject    string    `json:"subject"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type IStore interface {
+	Insert(ctx context.Context, entry Entry) error
+}
+
+var store IStore = NewLogStore(os.Stdout)
+
+func SetStore(s IStore) {
+	store = s
+}
+
+// Record writes entry to the audit log, stamped with the current time.
+func Record(ctx context.Context, entry Entry) error {
+	entry.CreatedAt = time.Now()
+
+	return store.Insert(ctx, entry)
+}
+
+type logStore struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewLogStore writes entries as JSON lines to writer.
+func NewLogStore(writer io.Writer) IStore {
+	return &logStore{writer: writer}
+}
+
+func (store *logStore) Insert(ctx context.Context, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, err = fmt.Fprintf(store.writer, "audit: %s\n", line)
+
+	return err
+}
+
+type mysqlStore struct {
+	database_connection *sql.DB
+	table               string
+}
+
+func NewMySQLStore(db *sql.DB, table_name string) IStore {
+	return &mysqlStore{
+		database_connection: db,
+		table:               table_name,
+	}
+}
+
+func (store *mysqlStore) Insert(ctx context.Context, entry Entry) error {
+	sql_query := fmt.Sprintf(
+		`INSERT INTO %s(
+			action,
+			actor,
+			subject,
+			method,
+			path,
+			status_code,
+			remote_addr,
+			detail,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, store.table)
+
+	_, err := store.database_connection.ExecContext(ctx, sql_query, entry.Action, entry.Actor, entry.Subject, entry.Method, entry.Path, entry.StatusCode, entry.RemoteAddr, entry.Detail, entry.CreatedAt)
+
+	return err
+}
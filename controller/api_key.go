@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type IApiKey interface {
+	Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Delete(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+}
+
+type apiKey struct {
+	model model.IApiKeyModel
+}
+
+func NewApiKey(model model.IApiKeyModel) IApiKey {
+	return &apiKey{
+		model: model,
+	}
+}
+
+// refuseDelegated answers 403 with message when the caller is not the user
+// itself but one of its API keys, OAuth clients or an impersonation token,
+// see auth.JwtClaims.IsDelegated. It reports whether it answered.
+func refuseDelegated(writer http.ResponseWriter, request *http.Request, message string) bool {
+	res := response.New()
+
+	claims, err := auth.ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return true
+	}
+
+	if !claims.IsDelegated() {
+		return false
+	}
+
+	res.Message = message
+
+	writer.WriteHeader(http.StatusForbidden)
+	writer.Write(res.ToJson())
+
+	return true
+}
+
+func (c *apiKey) Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	// keys can only be made by the user, a key must not mint its own successors
+	if refuseDelegated(writer, request, "endpoint ini hanya dapat digunakan oleh user") {
+		return
+	}
+
+	payload := model.ApiKey{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = validator.New().Struct(payload)
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !auth.IsValidScope(scope) {
+			res.Message = "scope tidak valid: " + scope
+
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(res.ToJson())
+
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		res.Message = "expires_at harus di masa depan"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	new_api_key, key_str, err := c.model.Insert(request.Context(), payload.Name, payload.Scopes, payload.ExpiresAt)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil membuat api key baru. simpan api_key, nilainya tidak akan ditampilkan lagi"
+	res.Data["api_key"] = key_str
+	res.Data["key"] = new_api_key
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(res.ToJson())
+}
+
+func (c *apiKey) ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	// a key scoped to anything at all must not see or revoke its siblings
+	if refuseDelegated(writer, request, "endpoint ini hanya dapat digunakan oleh user") {
+		return
+	}
+
+	api_keys, err := c.model.SelectAll(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil mendapatkan data api key"
+	res.Data["api_keys"] = api_keys
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *apiKey) Delete(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	if refuseDelegated(writer, request, "endpoint ini hanya dapat digunakan oleh user") {
+		return
+	}
+
+	id, err := uuid.Parse(params.ByName("api_key_id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = c.model.Delete(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Message = "api key tidak ditemukan"
+
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write(res.ToJson())
+
+			return
+		}
+
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeApiKeyModel records whether a handler got as far as the model.
+type fakeApiKeyModel struct {
+	model.IApiKeyModel
+	called bool
+}
+
+func (fake *fakeApiKeyModel) SelectAll(ctx context.Context) ([]model.ApiKey, error) {
+	fake.called = true
+
+	return []model.ApiKey{}, nil
+}
+
+func (fake *fakeApiKeyModel) Delete(ctx context.Context, id uuid.UUID) error {
+	fake.called = true
+
+	return nil
+}
+
+// delegated_claims are the callers other than the user itself.
+var delegated_claims = map[string]*auth.JwtClaims{
+	"api key":       {Email: "user@email.com", ApiKeyId: uuid.NewString(), Scope: "customers:read"},
+	"oauth client":  {Email: "user@email.com", ClientId: uuid.NewString(), Scope: "customers:read"},
+	"impersonation": {Email: "user@email.com", Actor: &auth.Actor{Email: "admin@email.com", AllowWrite: true}},
+}
+
+func serveWithClaims(handle httprouter.Handle, claims *auth.JwtClaims, method, body string, params httprouter.Params) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/", strings.NewReader(body))
+	request = request.WithContext(auth.WithClaims(request.Context(), claims))
+	recorder := httptest.NewRecorder()
+	handle(recorder, request, params)
+
+	return recorder
+}
+
+func TestApiKeyDelegated(t *testing.T) {
+	params := httprouter.Params{{Key: "api_key_id", Value: uuid.NewString()}}
+
+	for name, claims := range delegated_claims {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeApiKeyModel{}
+			api_key_controller := NewApiKey(fake)
+
+			recorder := serveWithClaims(api_key_controller.Create, claims, http.MethodPost, `{"name":"key"}`, nil)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			recorder = serveWithClaims(api_key_controller.ReadAll, claims, http.MethodGet, "", nil)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			recorder = serveWithClaims(api_key_controller.Delete, claims, http.MethodDelete, "", params)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			assert.False(t, fake.called)
+		})
+	}
+
+	t.Run("user", func(t *testing.T) {
+		fake := &fakeApiKeyModel{}
+		api_key_controller := NewApiKey(fake)
+		claims := &auth.JwtClaims{Email: "user@email.com"}
+
+		recorder := serveWithClaims(api_key_controller.ReadAll, claims, http.MethodGet, "", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = serveWithClaims(api_key_controller.Delete, claims, http.MethodDelete, "", params)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+		assert.True(t, fake.called)
+	})
+}
@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type IClient interface {
+	Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Delete(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Token(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+}
+
+type client struct {
+	model model.IClientModel
+}
+
+func NewClient(model model.IClientModel) IClient {
+	return &client{
+		model: model,
+	}
+}
+
+func (c *client) Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	// a client or an API key must not be able to mint more clients for its owner
+	if refuseDelegated(writer, request, "client tidak dapat mendaftarkan client lain") {
+		return
+	}
+
+	payload := model.Client{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = validator.New().Struct(payload)
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !auth.IsValidScope(scope) {
+			res.Message = "scope tidak valid: " + scope
+
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write(res.ToJson())
+
+			return
+		}
+	}
+
+	new_client, secret, err := c.model.Insert(request.Context(), payload.Name, payload.Scopes)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil mendaftarkan client baru. simpan client_secret, nilainya tidak akan ditampilkan lagi"
+	res.Data["client"] = new_client
+	res.Data["client_id"] = new_client.Id.String()
+	res.Data["client_secret"] = secret
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(res.ToJson())
+}
+
+func (c *client) ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	// nor list or delete them, the client that issued the token included
+	if refuseDelegated(writer, request, "endpoint ini hanya dapat digunakan oleh user") {
+		return
+	}
+
+	clients, err := c.model.SelectAll(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil mendapatkan data client"
+	res.Data["clients"] = clients
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *client) Delete(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	if refuseDelegated(writer, request, "endpoint ini hanya dapat digunakan oleh user") {
+		return
+	}
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = c.model.Delete(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Message = "client tidak ditemukan"
+
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write(res.ToJson())
+
+			return
+		}
+
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OauthToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func writeOauthJson(writer http.ResponseWriter, status_code int, body interface{}) {
+	json_encoded, _ := json.Marshal(body)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Pragma", "no-cache")
+	writer.WriteHeader(status_code)
+	writer.Write(json_encoded)
+}
+
+// Token implements the OAuth2 client credentials grant (RFC 6749 section
+// 4.4). Clients authenticate with HTTP Basic or client_id/client_secret form
+// fields, and responses follow the RFC instead of the usual envelope.
+func (c *client) Token(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	err := request.ParseForm()
+	if err != nil {
+		writeOauthJson(writer, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+
+		return
+	}
+
+	if request.PostForm.Get("grant_type") != "client_credentials" {
+		writeOauthJson(writer, http.StatusBadRequest, oauthError{Error: "unsupported_grant_type"})
+
+		return
+	}
+
+	client_id, client_secret, ok := request.BasicAuth()
+	if !ok {
+		client_id = request.PostForm.Get("client_id")
+		client_secret = request.PostForm.Get("client_secret")
+	}
+
+	id, err := uuid.Parse(client_id)
+	if err != nil || client_secret == "" {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeOauthJson(writer, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+
+		return
+	}
+
+	registered_client, err := c.model.SelectById(request.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+
+		writeOauthJson(writer, http.StatusInternalServerError, oauthError{Error: "server_error"})
+
+		return
+	}
+
+	if err != nil || !registered_client.VerifySecret(client_secret) {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeOauthJson(writer, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+
+		return
+	}
+
+	scopes := strings.Fields(request.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = registered_client.Scopes
+	}
+
+	for _, scope := range scopes {
+		if !registered_client.HasScope(scope) {
+			writeOauthJson(writer, http.StatusBadRequest, oauthError{Error: "invalid_scope", ErrorDescription: scope})
+
+			return
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	token, claims, err := auth.GenerateClientToken(registered_client.Id.String(), registered_client.OwnerEmail, []string{registered_client.OwnerRole}, registered_client.OrganizationId, scope)
+	if err != nil {
+		log.Println(err)
+
+		writeOauthJson(writer, http.StatusInternalServerError, oauthError{Error: "server_error"})
+
+		return
+	}
+
+	remote_addr, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		remote_addr = request.RemoteAddr
+	}
+
+	err = c.model.RecordIssuance(request.Context(), registered_client, claims, remote_addr)
+	if err != nil {
+		log.Println(err)
+
+		writeOauthJson(writer, http.StatusInternalServerError, oauthError{Error: "server_error"})
+
+		return
+	}
+
+	writeOauthJson(writer, http.StatusOK, OauthToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.AccessTokenLifetime().Seconds()),
+		Scope:       scope,
+	})
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClientModel records whether a handler got as far as the model.
+type fakeClientModel struct {
+	model.IClientModel
+	called bool
+}
+
+func (fake *fakeClientModel) SelectAll(ctx context.Context) ([]model.Client, error) {
+	fake.called = true
+
+	return []model.Client{}, nil
+}
+
+func (fake *fakeClientModel) Delete(ctx context.Context, id uuid.UUID) error {
+	fake.called = true
+
+	return nil
+}
+
+func TestClientDelegated(t *testing.T) {
+	params := httprouter.Params{{Key: "id", Value: uuid.NewString()}}
+
+	for name, claims := range delegated_claims {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeClientModel{}
+			client_controller := NewClient(fake)
+
+			recorder := serveWithClaims(client_controller.Create, claims, http.MethodPost, `{"name":"client"}`, nil)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			recorder = serveWithClaims(client_controller.ReadAll, claims, http.MethodGet, "", nil)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			recorder = serveWithClaims(client_controller.Delete, claims, http.MethodDelete, "", params)
+			assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+			assert.False(t, fake.called)
+		})
+	}
+
+	t.Run("user", func(t *testing.T) {
+		fake := &fakeClientModel{}
+		client_controller := NewClient(fake)
+		claims := &auth.JwtClaims{Email: "user@email.com"}
+
+		recorder := serveWithClaims(client_controller.ReadAll, claims, http.MethodGet, "", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = serveWithClaims(client_controller.Delete, claims, http.MethodDelete, "", params)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+		assert.True(t, fake.called)
+	})
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +15,7 @@ import (
 	"github.com/mmiftahrzki/go-rest-api/model"
 	"github.com/mmiftahrzki/go-rest-api/response"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -26,6 +29,10 @@ type ICustomer interface {
 	ReadById(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
 	UpdateById(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
 	Delete(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	ReadShares(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Share(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Unshare(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Transfer(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
 }
 
 type customer struct {
@@ -54,6 +61,16 @@ func (c *customer) Create(writer http.ResponseWriter, request *http.Request, par
 	if err != nil {
 		log.Println(err)
 
+		if errors.Is(err, model.ErrNoOrganization) {
+			res.Message = "Anda tidak sedang bekerja di organisasi mana pun, pilih organisasi di /api/organizations"
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusForbidden)
+			writer.Write(res.ToJson())
+
+			return
+		}
+
 		mysql_error, ok := err.(*mysql.MySQLError)
 		if ok {
 			if mysql_error.Number == 1062 {
@@ -177,7 +194,7 @@ func (c *customer) ReadNext(writer http.ResponseWriter, request *http.Request, p
 	}
 
 	if reflect.ValueOf(customer).IsZero() {
-		res.Message = http.StatusText(http.StatusNotFound)
+		res.Message = fmt.Sprintf("customer dengan id: %s tidak ditemukan", id)
 
 		writer.Header().Set("Content-Type", "application/json")
 		writer.WriteHeader(http.StatusNotFound)
@@ -238,7 +255,7 @@ func (c *customer) ReadPrev(writer http.ResponseWriter, request *http.Request, p
 	}
 
 	if reflect.ValueOf(customer).IsZero() {
-		res.Message = http.StatusText(http.StatusNotFound)
+		res.Message = fmt.Sprintf("customer dengan id: %s tidak ditemukan", id)
 
 		writer.Header().Set("Content-Type", "application/json")
 		writer.WriteHeader(http.StatusNotFound)
@@ -311,23 +328,7 @@ func (c *customer) UpdateById(writer http.ResponseWriter, request *http.Request,
 
 	customer, err := c.model.Update(request.Context(), payload)
 	if err != nil {
-		log.Println(err)
-
-		mysql_error, ok := err.(*mysql.MySQLError)
-		if ok {
-			if mysql_error.Number == 1292 {
-				res.Message = http.StatusText(http.StatusBadRequest)
-
-				writer.Header().Set("Content-Type", "application/json")
-				writer.WriteHeader(http.StatusBadRequest)
-				writer.Write(res.ToJson())
-			}
-
-			return
-		}
-
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		writeCustomerError(writer, id, err)
 
 		return
 	}
@@ -358,10 +359,112 @@ func (c *customer) Delete(writer http.ResponseWriter, request *http.Request, par
 
 	err = c.model.Delete(request.Context(), id)
 	if err != nil {
-		log.Println(err)
+		writeCustomerError(writer, id, err)
 
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type SharePayload struct {
+	Access string `json:"access"`
+}
+
+type TransferPayload struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+func (c *customer) ReadShares(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	shares, err := c.model.SelectShares(request.Context(), id)
+	if err != nil {
+		writeCustomerError(writer, id, err)
+
+		return
+	}
+
+	res.Message = "berhasil mendapatkan data user yang dapat mengakses customer"
+	res.Data["shares"] = shares
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+// Share gives the user in :email read or write access to the customer, or
+// changes the access it was given before.
+func (c *customer) Share(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	payload := SharePayload{}
+	err = json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil || !model.IsValidCustomerAccess(payload.Access) {
+		res.Message = "access harus berisi read atau write"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	share, err := c.model.Share(request.Context(), id, params.ByName("email"), payload.Access)
+	if err != nil {
+		writeCustomerError(writer, id, err)
+
+		return
+	}
+
+	res.Message = "berhasil membagikan customer"
+	res.Data["share"] = share
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *customer) Unshare(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = c.model.Unshare(request.Context(), id, params.ByName("email"))
+	if err != nil {
+		writeCustomerError(writer, id, err)
 
 		return
 	}
@@ -369,3 +472,102 @@ func (c *customer) Delete(writer http.ResponseWriter, request *http.Request, par
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusNoContent)
 }
+
+func (c *customer) Transfer(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	payload := TransferPayload{}
+	err = json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	transfer, err := c.model.Transfer(request.Context(), id, payload.Email)
+	if err != nil {
+		writeCustomerError(writer, id, err)
+
+		return
+	}
+
+	res.Message = "berhasil memindahkan kepemilikan customer"
+	res.Data["transfer"] = transfer
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+// writeCustomerError answers a failed change to the customer with id. Like
+// reads, changes to customers the caller cannot see are answered with 404.
+func writeCustomerError(writer http.ResponseWriter, id uuid.UUID, err error) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	var mysql_error *mysql.MySQLError
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res.Message = fmt.Sprintf("customer dengan id: %s tidak ditemukan", id)
+
+		writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, model.ErrCustomerReadOnly):
+		res.Message = "Anda tidak memiliki akses untuk mengubah customer ini"
+
+		writer.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, model.ErrCustomerOwner):
+		res.Message = "user tersebut sudah menjadi pemilik customer"
+
+		writer.WriteHeader(http.StatusConflict)
+	case errors.Is(err, model.ErrUserNotFound):
+		res.Message = "user tidak ditemukan"
+
+		writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, model.ErrShareNotFound):
+		res.Message = "customer tidak dibagikan kepada user tersebut"
+
+		writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, model.ErrNotOrganizationMember):
+		res.Message = "user harus anggota organisasi customer"
+
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+	case errors.Is(err, model.ErrNothingToUpdate):
+		res.Message = "tidak ada data customer yang diperbarui"
+
+		writer.WriteHeader(http.StatusBadRequest)
+	case errors.As(err, &mysql_error) && mysql_error.Number == 1292:
+		res.Message = http.StatusText(http.StatusBadRequest)
+
+		writer.WriteHeader(http.StatusBadRequest)
+	case errors.As(err, &mysql_error) && mysql_error.Number == 1062:
+		res.Message = "customer dengan username tersebut sudah ada"
+
+		writer.WriteHeader(http.StatusConflict)
+	default:
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+	}
+
+	writer.Write(res.ToJson())
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/database/databasetest"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCustomerRouter(customer_controller ICustomer) *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/api/customers/:id", customer_controller.ReadById)
+	router.GET("/api/customers/:id/next", customer_controller.ReadNext)
+	router.GET("/api/customers/:id/prev", customer_controller.ReadPrev)
+	router.PUT("/api/customers/:id", customer_controller.UpdateById)
+	router.DELETE("/api/customers/:id", customer_controller.Delete)
+
+	return router
+}
+
+// TestCustomerOtherTenant asks for the customer of another organization by
+// its id, it has to look like it does not exist.
+func TestCustomerOtherTenant(t *testing.T) {
+	db := databasetest.Open(t)
+	customer_model := model.NewCustomer(db, "customer")
+	router := newCustomerRouter(NewCustomer(customer_model))
+
+	tenant_a := databasetest.NewTenant(t, db)
+	tenant_b := databasetest.NewTenant(t, db)
+	ctx_a := tenant_a.Context(tenant_a.Admin)
+	ctx_b := tenant_b.Context(tenant_b.Admin)
+
+	id_b, err := customer_model.Insert(ctx_b, "customer", "customer@email.com", "Customer B", "other", time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	serve := func(ctx context.Context, method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	path := "/api/customers/" + id_b.String()
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", http.MethodGet, path, "", http.StatusOK},
+		{"next", http.MethodGet, path + "/next", "", http.StatusOK},
+		{"prev", http.MethodGet, path + "/prev", "", http.StatusOK},
+		{"PUT", http.MethodPut, path, `{"fullname":"Changed"}`, http.StatusOK},
+		{"DELETE", http.MethodDelete, path, "", http.StatusNoContent},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(ctx_a, test.method, test.path, test.body)
+			assert.Equal(t, http.StatusNotFound, recorder.Code, recorder.Body.String())
+
+			customer, err := customer_model.SelectById(ctx_b, id_b)
+			require.NoError(t, err)
+			assert.Equal(t, id_b, customer.Id)
+			assert.Equal(t, "Customer B", customer.Fullname)
+
+			// the owner still reaches it through the same request
+			if test.method == http.MethodPut {
+				test.body = `{"fullname":"Customer B"}`
+			}
+
+			recorder = serve(ctx_b, test.method, test.path, test.body)
+			assert.Equal(t, test.status, recorder.Code, recorder.Body.String())
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type IOrganization interface {
+	Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	Switch(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	ReadMembers(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	AddMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	UpdateMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+	RemoveMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+}
+
+type organization struct {
+	model      model.IOrganizationModel
+	user_model model.IUserModel
+}
+
+func NewOrganization(model model.IOrganizationModel, user_model model.IUserModel) IOrganization {
+	return &organization{
+		model:      model,
+		user_model: user_model,
+	}
+}
+
+type OrganizationView struct {
+	model.Organization
+	Active bool `json:"active"`
+}
+
+type MemberPayload struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+	Role  string `json:"role"`
+}
+
+// caller returns the claims of a user managing its organizations. Tokens
+// acting on behalf of a user are turned away, the response is written then.
+func (c *organization) caller(writer http.ResponseWriter, request *http.Request) *auth.JwtClaims {
+	res := response.New()
+
+	claims, err := auth.ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return nil
+	}
+
+	if claims.IsDelegated() {
+		res.Message = "endpoint ini hanya dapat digunakan oleh user"
+
+		writer.WriteHeader(http.StatusForbidden)
+		writer.Write(res.ToJson())
+
+		return nil
+	}
+
+	return claims
+}
+
+// membership returns the organization in :id and the caller's role in it.
+// Organizations the caller is not a member of are answered with 404 like
+// ones that do not exist, the response is written then.
+func (c *organization) membership(writer http.ResponseWriter, request *http.Request, params httprouter.Params, claims *auth.JwtClaims) (uuid.UUID, string, bool) {
+	res := response.New()
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		res.Message = "id tidak valid"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return uuid.Nil, "", false
+	}
+
+	member, err := c.model.FindMember(request.Context(), id, claims.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Message = "organisasi tidak ditemukan"
+
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write(res.ToJson())
+
+			return uuid.Nil, "", false
+		}
+
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return uuid.Nil, "", false
+	}
+
+	return id, member.Role, true
+}
+
+func writeNotOrganizationAdmin(writer http.ResponseWriter) {
+	res := response.New()
+	res.Message = "hanya admin organisasi yang dapat mengelola anggota"
+
+	writer.WriteHeader(http.StatusForbidden)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) Create(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	payload := model.Organization{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	new_organization, err := c.model.Insert(request.Context(), payload.Name, claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil membuat organisasi baru, pindah ke organisasi ini melalui /api/organizations/" + new_organization.Id.String() + "/switch"
+	res.Data["organization"] = new_organization
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) ReadAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	organizations, err := c.model.SelectForMember(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	organization_views := make([]OrganizationView, 0, len(organizations))
+	for _, organization := range organizations {
+		organization_views = append(organization_views, OrganizationView{
+			Organization: organization,
+			Active:       strings.EqualFold(organization.Id.String(), claims.OrganizationId),
+		})
+	}
+
+	res.Message = "berhasil mendapatkan data organisasi"
+	res.Data["organizations"] = organization_views
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+// Switch makes the organization in :id the one the caller works in. The
+// returned access token works in it right away, the caller's other sessions
+// follow on their next refresh.
+func (c *organization) Switch(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	session_id, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		res.Message = "token ini tidak berasal dari sesi login, silakan login kembali"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	id, _, ok := c.membership(writer, request, params, claims)
+	if !ok {
+		return
+	}
+
+	user, err := c.user_model.FindByEmail(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	user.OrganizationId = &id
+	_, err = c.user_model.Update(request.Context(), user)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	token, err := auth.GenerateSessionToken(user.Email, []string{user.Role}, id.String(), session_id)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil pindah organisasi"
+	res.Data["token"] = token
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) ReadMembers(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	id, _, ok := c.membership(writer, request, params, claims)
+	if !ok {
+		return
+	}
+
+	members, err := c.model.SelectMembers(request.Context(), id)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil mendapatkan data anggota organisasi"
+	res.Data["members"] = members
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) AddMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	id, role, ok := c.membership(writer, request, params, claims)
+	if !ok {
+		return
+	}
+
+	if role != rbac.OrganizationRoleAdmin {
+		writeNotOrganizationAdmin(writer)
+
+		return
+	}
+
+	payload := MemberPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if payload.Role == "" {
+		payload.Role = rbac.OrganizationRoleMember
+	}
+
+	if err != nil || !rbac.IsValidOrganizationRole(payload.Role) {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	_, err = c.user_model.FindByEmail(request.Context(), payload.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Message = "user dengan email: " + payload.Email + " tidak ditemukan"
+
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write(res.ToJson())
+
+			return
+		}
+
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	member, err := c.model.AddMember(request.Context(), id, payload.Email, payload.Role)
+	if err != nil {
+		if errors.Is(err, model.ErrMemberExists) {
+			res.Message = payload.Email + " sudah menjadi anggota organisasi"
+
+			writer.WriteHeader(http.StatusConflict)
+			writer.Write(res.ToJson())
+
+			return
+		}
+
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	res.Message = "berhasil menambahkan anggota organisasi"
+	res.Data["member"] = member
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) UpdateMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	id, role, ok := c.membership(writer, request, params, claims)
+	if !ok {
+		return
+	}
+
+	if role != rbac.OrganizationRoleAdmin {
+		writeNotOrganizationAdmin(writer)
+
+		return
+	}
+
+	payload := MemberPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil || !rbac.IsValidOrganizationRole(payload.Role) {
+		res.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(res.ToJson())
+
+		return
+	}
+
+	err = c.model.UpdateMember(request.Context(), id, params.ByName("email"), payload.Role)
+	if err != nil {
+		c.writeMemberError(writer, err)
+
+		return
+	}
+
+	res.Message = "berhasil memperbarui peran anggota organisasi"
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+// RemoveMember lets an admin remove a member, and any member leave.
+func (c *organization) RemoveMember(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	res := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	claims := c.caller(writer, request)
+	if claims == nil {
+		return
+	}
+
+	id, role, ok := c.membership(writer, request, params, claims)
+	if !ok {
+		return
+	}
+
+	email := params.ByName("email")
+	if role != rbac.OrganizationRoleAdmin && !strings.EqualFold(email, claims.Email) {
+		writeNotOrganizationAdmin(writer)
+
+		return
+	}
+
+	err := c.model.RemoveMember(request.Context(), id, email)
+	if err != nil {
+		c.writeMemberError(writer, err)
+
+		return
+	}
+
+	res.Message = "berhasil mengeluarkan anggota organisasi"
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(res.ToJson())
+}
+
+func (c *organization) writeMemberError(writer http.ResponseWriter, err error) {
+	res := response.New()
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res.Message = "anggota organisasi tidak ditemukan"
+
+		writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, model.ErrLastOrganizationAdmin):
+		res.Message = "organisasi harus memiliki setidaknya satu admin"
+
+		writer.WriteHeader(http.StatusConflict)
+	default:
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+	}
+
+	writer.Write(res.ToJson())
+}
@@ -0,0 +1,242 @@
+// Package databasetest gives tests a MySQL database to run the models
+// against. It is set with TEST_DATABASE_DSN, tests are skipped without it.
+// Some queries name the portfolio database, the DSN has to point at it.
+package databasetest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+)
+
+// tables are the tables the models read and write, as misc/schema.sql
+// leaves them.
+var tables = []string{
+	`CREATE TABLE IF NOT EXISTS user(
+		id BINARY(16) NOT NULL,
+		id_text CHAR(36) NOT NULL,
+		email VARCHAR(100) NOT NULL,
+		password VARCHAR(255) NOT NULL,
+		fullname VARCHAR(255) NOT NULL,
+		role VARCHAR(20) NOT NULL DEFAULT 'staff',
+		verified_at TIMESTAMP NULL DEFAULT NULL,
+		totp_secret VARCHAR(255) NULL DEFAULT NULL,
+		totp_enabled_at TIMESTAMP NULL DEFAULT NULL,
+		totp_last_step BIGINT NOT NULL DEFAULT 0,
+		deactivated_at TIMESTAMP NULL DEFAULT NULL,
+		organization_id CHAR(36) NULL DEFAULT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(id),
+		UNIQUE(id_text),
+		UNIQUE(email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS customer(
+		id BINARY(16) NOT NULL,
+		id_text CHAR(36) NOT NULL,
+		username VARCHAR(100) NOT NULL,
+		email VARCHAR(100) NOT NULL,
+		fullname VARCHAR(255) NOT NULL,
+		gender VARCHAR(10) NOT NULL,
+		date_of_birth DATE NULL DEFAULT NULL,
+		created_at TIMESTAMP NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		organization_id CHAR(36) NULL DEFAULT NULL,
+		PRIMARY KEY(id),
+		UNIQUE(id_text),
+		INDEX(organization_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS organization(
+		id BINARY(16) NOT NULL,
+		id_text CHAR(36) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		PRIMARY KEY(id),
+		UNIQUE(id_text)
+	)`,
+	`CREATE TABLE IF NOT EXISTS organization_member(
+		organization_id CHAR(36) NOT NULL,
+		email VARCHAR(100) NOT NULL,
+		role VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(organization_id, email),
+		INDEX(email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS customer_share(
+		customer_id CHAR(36) NOT NULL,
+		email VARCHAR(100) NOT NULL,
+		access VARCHAR(10) NOT NULL,
+		shared_by VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(customer_id, email),
+		INDEX(email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_key(
+		id BINARY(16) NOT NULL,
+		id_text CHAR(36) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		scope VARCHAR(255) NOT NULL,
+		owner_email VARCHAR(100) NOT NULL,
+		expires_at TIMESTAMP NULL DEFAULT NULL,
+		last_used_at TIMESTAMP NULL DEFAULT NULL,
+		revoked_at TIMESTAMP NULL DEFAULT NULL,
+		created_at TIMESTAMP NOT NULL,
+		organization_id CHAR(36) NULL DEFAULT NULL,
+		PRIMARY KEY(id),
+		UNIQUE(id_text),
+		UNIQUE(key_hash),
+		INDEX(owner_email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS oauth_client(
+		id BINARY(16) NOT NULL,
+		id_text CHAR(36) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		scope VARCHAR(255) NOT NULL,
+		owner_email VARCHAR(100) NOT NULL,
+		secret_hash VARCHAR(72) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP NULL DEFAULT NULL,
+		organization_id CHAR(36) NULL DEFAULT NULL,
+		PRIMARY KEY(id),
+		UNIQUE(id_text),
+		INDEX(owner_email)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_token(
+		token_hash CHAR(64) NOT NULL,
+		email VARCHAR(100) NOT NULL,
+		purpose VARCHAR(30) NOT NULL,
+		data VARCHAR(255) NULL DEFAULT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP NULL DEFAULT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(token_hash),
+		INDEX(email, purpose)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_recovery_code(
+		user_id CHAR(36) NOT NULL,
+		code_hash CHAR(64) NOT NULL,
+		used_at TIMESTAMP NULL DEFAULT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(user_id, code_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS customer_transfer(
+		id BIGINT AUTO_INCREMENT,
+		customer_id CHAR(36) NOT NULL,
+		from_email VARCHAR(100) NOT NULL,
+		to_email VARCHAR(100) NOT NULL,
+		transferred_by VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(id),
+		INDEX(customer_id)
+	)`,
+}
+
+// Open connects to TEST_DATABASE_DSN and creates the tables that are
+// missing. Tables are never dropped, tests keep to rows of their own, see
+// NewTenant.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, sql_query := range tables {
+		_, err = db.Exec(sql_query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// Tenant is an organization of its own with two users: Admin, who created
+// the organization and is its admin, and Member.
+type Tenant struct {
+	OrganizationId string
+	Admin          string
+	Member         string
+}
+
+// NewTenant creates a tenant and deletes it when the test ends, along with
+// the customers of its organization and what they were shared with and
+// transferred to.
+func NewTenant(t testing.TB, db *sql.DB) Tenant {
+	t.Helper()
+
+	tenant := Tenant{
+		OrganizationId: strings.ToUpper(uuid.NewString()),
+		Admin:          "admin-" + uuid.NewString() + "@email.com",
+		Member:         "member-" + uuid.NewString() + "@email.com",
+	}
+
+	t.Cleanup(func() {
+		for _, sql_query := range []string{
+			"DELETE FROM customer_share WHERE customer_id IN (SELECT id_text FROM customer WHERE organization_id=?)",
+			"DELETE FROM customer_transfer WHERE customer_id IN (SELECT id_text FROM customer WHERE organization_id=?)",
+			"DELETE FROM customer WHERE organization_id=?",
+			"DELETE FROM organization_member WHERE organization_id=?",
+			"DELETE FROM organization WHERE id_text=?",
+			"DELETE FROM user WHERE organization_id=?",
+		} {
+			_, err := db.Exec(sql_query, tenant.OrganizationId)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	now := time.Now()
+
+	sql_query := "INSERT INTO organization(id, id_text, name, created_at, created_by) VALUES (unhex(replace(?, '-', '')), ?, ?, ?, ?)"
+	_, err := db.Exec(sql_query, tenant.OrganizationId, tenant.OrganizationId, tenant.OrganizationId, now, tenant.Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for email, role := range map[string]string{tenant.Admin: rbac.OrganizationRoleAdmin, tenant.Member: rbac.OrganizationRoleMember} {
+		id := uuid.New()
+
+		sql_query = "INSERT INTO user(id, id_text, email, password, fullname, role, verified_at, organization_id, created_at) VALUES (unhex(replace(?, '-', '')), UPPER(?), ?, '', ?, ?, ?, ?, ?)"
+		_, err = db.Exec(sql_query, id.String(), id.String(), email, email, rbac.RoleStaff, now, tenant.OrganizationId, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sql_query = "INSERT INTO organization_member(organization_id, email, role, created_at) VALUES (?, ?, ?, ?)"
+		_, err = db.Exec(sql_query, tenant.OrganizationId, email, role, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return tenant
+}
+
+// Context is the context of a request made by email with a staff token
+// working in the tenant's organization.
+func (tenant Tenant) Context(email string) context.Context {
+	return auth.WithClaims(context.Background(), &auth.JwtClaims{
+		Email:          email,
+		Roles:          []string{rbac.RoleStaff},
+		OrganizationId: tenant.OrganizationId,
+	})
+}
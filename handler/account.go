@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/mailer"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/password"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,max=32"`
+}
+
+type ChangeEmailPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewEmail        string `json:"new_email" validate:"required,email,max=100"`
+}
+
+// MeOnly guards the routes of the signed-in user's own account. They share
+// /api/users/:id with the admin routes, so only "me" is accepted as :id.
+func MeOnly(next httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if params.ByName("id") != "me" {
+			response := response.New()
+			response.Message = "sumber daya yang Anda cari tidak ditemukan"
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write(response.ToJson())
+
+			return
+		}
+
+		next(writer, request, params)
+	}
+}
+
+// checkCurrentPassword re-authenticates a signed-in user and returns it.
+// Wrong guesses count towards the sign-in lockout like on /api/auth/signin.
+func checkCurrentPassword(request *http.Request, email, plain_password string) (model.User, bool, time.Duration, error) {
+	retry_after := signInRetryAfter(request, email)
+	if retry_after > 0 {
+		return model.User{}, false, retry_after, nil
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), email)
+	if err != nil {
+		releaseSignIn(request, email)
+
+		return model.User{}, false, 0, err
+	}
+
+	ok, needs_rehash, err := password.Verify(plain_password, user.PasswordHash)
+	if err != nil {
+		releaseSignIn(request, email)
+
+		return model.User{}, false, 0, err
+	}
+
+	if !ok {
+		return model.User{}, false, signInFailed(request, email), nil
+	}
+
+	releaseSignIn(request, email)
+
+	if needs_rehash {
+		rehashPassword(request.Context(), user, plain_password)
+	}
+
+	return user, true, 0, nil
+}
+
+func ChangePassword(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	payload := ChangePasswordPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user, ok, retry_after, err := checkCurrentPassword(request, claims.Email, payload.CurrentPassword)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if !ok {
+		if retry_after > 0 {
+			setRetryAfter(writer, retry_after)
+		}
+
+		message = "password saat ini salah"
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	user.Password = payload.NewPassword
+	_, err = user_model.Update(request.Context(), user)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = auth.RevokeAllTokens(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	// every other session is gone, the caller continues with a fresh pair
+	token, refresh_token, err := issueTokens(request, claims.Email, firstRole(claims), claims.OrganizationId)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mengubah password, semua sesi lain telah dikeluarkan"
+	response.Data["token"] = token
+	response.Data["refresh_token"] = refresh_token
+}
+
+func firstRole(claims *auth.JwtClaims) string {
+	if len(claims.Roles) == 0 {
+		return ""
+	}
+
+	return claims.Roles[0]
+}
+
+func ChangeEmail(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	payload := ChangeEmailPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	_, ok, retry_after, err := checkCurrentPassword(request, claims.Email, payload.CurrentPassword)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if !ok {
+		if retry_after > 0 {
+			setRetryAfter(writer, retry_after)
+		}
+
+		message = "password saat ini salah"
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	_, err = user_model.FindByEmail(request.Context(), payload.NewEmail)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+
+		return
+	}
+
+	if err == nil {
+		message = fmt.Sprintf("user dengan email: %s sudah ada", payload.NewEmail)
+		status_code = http.StatusConflict
+
+		return
+	}
+
+	token, err := user_token_model.Insert(request.Context(), claims.Email, model.UserTokenEmailChange, payload.NewEmail, email_verification_lifetime)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = mailer_client.Send(request.Context(), mailer.Message{
+		To:      payload.NewEmail,
+		Subject: "Konfirmasi perubahan email",
+		Body: fmt.Sprintf(
+			"Buka link berikut dalam %d jam untuk menggunakan alamat ini sebagai email akun Anda:\n%s",
+			int(email_verification_lifetime.Hours()), verificationUrl(token)),
+	})
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = mailer_client.Send(request.Context(), mailer.Message{
+		To:      claims.Email,
+		Subject: "Permintaan perubahan email",
+		Body:    fmt.Sprintf("Ada permintaan untuk mengubah email akun Anda menjadi %s. Email tidak akan berubah sebelum alamat baru dikonfirmasi.", payload.NewEmail),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+
+	status_code = http.StatusAccepted
+	message = "link konfirmasi telah dikirim ke email baru, email akun berubah setelah link tersebut dibuka"
+}
+
+// confirmEmailChange moves the account from old_email to new_email, along
+// with everything it owns, see model.IUserModel.ChangeEmail.
+func confirmEmailChange(ctx context.Context, old_email, new_email string) error {
+	err := user_model.ChangeEmail(ctx, old_email, new_email)
+	if err != nil {
+		return err
+	}
+
+	// tokens carry the old email, they have to be replaced by signing in again
+	return auth.RevokeAllTokens(ctx, old_email)
+}
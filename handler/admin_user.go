@@ -0,0 +1,514 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/audit"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const customers_reassign string = "reassign"
+const customers_delete string = "delete"
+
+// adminTargetUser loads the user named by the :id parameter. On failure it
+// returns the status code and message to answer with.
+func adminTargetUser(request *http.Request, params httprouter.Params) (model.User, int, string) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return model.User{}, http.StatusBadRequest, "id tidak valid"
+	}
+
+	user, err := user_model.FindById(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, http.StatusNotFound, "user tidak ditemukan"
+		}
+
+		log.Println(err)
+
+		return model.User{}, http.StatusInternalServerError, "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+	}
+
+	return user, http.StatusOK, ""
+}
+
+// isSelf reports whether user is the admin making the request. Admins may
+// not lock themselves out.
+func isSelf(request *http.Request, user model.User) bool {
+	claims, err := auth.ExtractAuthClaims(request.Context())
+
+	return err == nil && strings.EqualFold(claims.Email, user.Email)
+}
+
+// ReadUsers lists users oldest first, Max_limit at a time. The next page
+// starts after the user in ?after=.
+func ReadUsers(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	after := uuid.Nil
+	if request.URL.Query().Get("after") != "" {
+		var err error
+
+		after, err = uuid.Parse(request.URL.Query().Get("after"))
+		if err != nil {
+			message = "parameter after tidak valid"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+	}
+
+	users, err := user_model.List(request.Context(), after, model.Max_limit+1)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if len(users) == model.Max_limit+1 {
+		response.Data["__next"] = fmt.Sprintf("%s:%s/api/users?after=%s", os.Getenv("BASE_URL"), os.Getenv("PORT"), users[model.Max_limit-1].Id)
+
+		users = users[:model.Max_limit]
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mendapatkan data user"
+	response.Data["users"] = users
+}
+
+func ReadUserById(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mendapatkan data user"
+	response.Data["user"] = user
+}
+
+// DeactivateUser blocks a user from signing in and ends everything it is
+// signed in with. Its data stays until it is deleted.
+func DeactivateUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	if isSelf(request, user) {
+		message = "tidak dapat menonaktifkan akun sendiri"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	if user.DeactivatedAt == nil {
+		now := time.Now()
+		user.DeactivatedAt = &now
+
+		_, err := user_model.Update(request.Context(), user)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+
+		err = auth.RevokeAllTokens(request.Context(), user.Email)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil menonaktifkan user"
+	response.Data["user"] = user
+}
+
+func ReactivateUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	if user.DeactivatedAt != nil {
+		user.DeactivatedAt = nil
+
+		_, err := user_model.Update(request.Context(), user)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mengaktifkan kembali user"
+	response.Data["user"] = user
+}
+
+// ForcePasswordReset replaces the user's password with a random one, signs
+// it out everywhere and mails it a link to choose a new password.
+func ForcePasswordReset(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	random_password, err := randomPassword()
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	user.Password = random_password
+	_, err = user_model.Update(request.Context(), user)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = auth.RevokeAllTokens(request.Context(), user.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = sendPasswordResetEmail(request.Context(), user.Email, "Admin telah mereset password akun Anda, password lama tidak dapat digunakan lagi.", "Hubungi admin jika Anda tidak mengetahui alasannya.")
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "password user telah direset, link untuk membuat password baru telah dikirim ke " + user.Email
+}
+
+type ImpersonatePayload struct {
+	Reason     string `json:"reason" validate:"required,max=255"`
+	AllowWrite bool   `json:"allow_write"`
+}
+
+// ImpersonateUser gives an admin a short-lived token acting as another user,
+// to see what that user sees. The token is read only unless allow_write is
+// set, and everything done with it ends up in the audit log.
+func ImpersonateUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	payload := ImpersonatePayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload, alasan impersonasi (reason) wajib diisi"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	if isSelf(request, user) || user.Role == rbac.RoleAdmin {
+		message = "admin tidak dapat diimpersonasi"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	if user.DeactivatedAt != nil {
+		message = "user yang dinonaktifkan tidak dapat diimpersonasi"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	token, impersonation_claims, err := auth.GenerateImpersonationToken(claims, user.Email, []string{user.Role}, organizationIdOf(user), payload.AllowWrite)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	detail := payload.Reason
+	if payload.AllowWrite {
+		detail = "[write] " + detail
+	}
+
+	// without a record of it the token must not be handed out
+	err = audit.Record(request.Context(), audit.Entry{
+		Action:     audit.ActionImpersonationStart,
+		Actor:      claims.Email,
+		Subject:    user.Email,
+		Method:     request.Method,
+		Path:       request.URL.RequestURI(),
+		StatusCode: http.StatusOK,
+		RemoteAddr: clientIp(request),
+		Detail:     impersonation_claims.ID + " " + detail,
+	})
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil generate token impersonasi untuk " + user.Email
+	response.Data["token"] = token
+	response.Data["expires_in"] = int(auth.ImpersonationTokenLifetime().Seconds())
+	response.Data["allow_write"] = payload.AllowWrite
+}
+
+// DeleteUser removes a user. Customers it created have to go somewhere:
+// ?customers=reassign&to=<user id> hands them to another user, a member of
+// the organization of each of them, ?customers=delete deletes them. Without
+// either, a user that still owns customers is not deleted. Neither is the
+// last admin of an organization with other members.
+func DeleteUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	user, target_status, target_message := adminTargetUser(request, params)
+	if target_status != http.StatusOK {
+		status_code, message = target_status, target_message
+
+		return
+	}
+
+	if isSelf(request, user) {
+		message = "tidak dapat menghapus akun sendiri"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	claims, err := auth.ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	query := request.URL.Query()
+	deletion := model.UserDeletion{DeletedBy: claims.Email}
+
+	switch query.Get("customers") {
+	case "":
+	case customers_delete:
+		deletion.DeleteCustomers = true
+	case customers_reassign:
+		to, err := uuid.Parse(query.Get("to"))
+		if err != nil {
+			message = "parameter to harus berisi id user penerima customer"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		receiver, err := user_model.FindById(request.Context(), to)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				message = "user penerima customer tidak ditemukan"
+				status_code = http.StatusBadRequest
+
+				return
+			}
+
+			log.Println(err)
+
+			return
+		}
+
+		if receiver.Id == user.Id || receiver.DeactivatedAt != nil {
+			message = "user penerima customer harus user lain yang aktif"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		deletion.NewOwner = receiver.Email
+	default:
+		message = "parameter customers harus berisi reassign atau delete"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	customer_count, err := deleteUser(request.Context(), user, deletion)
+	if err != nil {
+		if errors.Is(err, model.ErrUserOwnsCustomers) {
+			message = fmt.Sprintf("user masih memiliki %d customer, pilih customers=reassign&to=<id user> atau customers=delete", customer_count)
+			status_code = http.StatusConflict
+			response.Data["customer_count"] = customer_count
+
+			return
+		}
+
+		if errors.Is(err, model.ErrLastOrganizationAdmin) {
+			message = "user adalah satu-satunya admin organisasi yang masih memiliki anggota lain, jadikan anggota lain admin terlebih dahulu"
+			status_code = http.StatusConflict
+
+			return
+		}
+
+		if errors.Is(err, model.ErrNotOrganizationMember) {
+			message = "user penerima customer harus anggota organisasi dari setiap customer"
+			status_code = http.StatusConflict
+
+			return
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			message = "user tidak ditemukan"
+			status_code = http.StatusNotFound
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil menghapus user"
+	response.Data["customer_count"] = customer_count
+}
+
+// deleteUser deletes user, see model.IUserModel.Delete, and revokes every
+// token it was issued.
+func deleteUser(ctx context.Context, user model.User, deletion model.UserDeletion) (int, error) {
+	customer_count, err := user_model.Delete(ctx, user.Id, deletion)
+	if err != nil {
+		return customer_count, err
+	}
+
+	// the email may be registered again, nothing issued to this user may
+	// carry over to the new account
+	return customer_count, auth.RevokeAllTokens(ctx, user.Email)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/response"
+	"github.com/mmiftahrzki/go-rest-api/throttle"
+)
+
+var email_attempts throttle.IAttemptStore = throttle.NewMemoryAttemptStore(throttle.Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+})
+
+var ip_attempts throttle.IAttemptStore = throttle.NewMemoryAttemptStore(throttle.Policy{
+	FreeAttempts:    20,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    100,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+})
+
+func SetAttemptStores(email_store, ip_store throttle.IAttemptStore) {
+	email_attempts = email_store
+	ip_attempts = ip_store
+}
+
+func clientIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+func emailAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(request *http.Request) string {
+	return "ip:" + clientIp(request)
+}
+
+// signInRetryAfter returns how long the email or the client address still
+// has to wait before it may try to sign in again. When it returns 0 an
+// attempt is reserved for both, to be ended with signInFailed or
+// releaseSignIn.
+func signInRetryAfter(request *http.Request, email string) time.Duration {
+	now := time.Now()
+
+	retry_after := email_attempts.Check(emailAttemptKey(email), now)
+	if retry_after > 0 {
+		return retry_after
+	}
+
+	retry_after = ip_attempts.Check(ipAttemptKey(request), now)
+	if retry_after > 0 {
+		email_attempts.Release(emailAttemptKey(email))
+	}
+
+	return retry_after
+}
+
+// releaseSignIn ends an attempt reserved by signInRetryAfter that did not
+// fail.
+func releaseSignIn(request *http.Request, email string) {
+	email_attempts.Release(emailAttemptKey(email))
+	ip_attempts.Release(ipAttemptKey(request))
+}
+
+// signInFailed ends an attempt reserved by signInRetryAfter as failed and
+// returns how long until the next attempt is allowed.
+func signInFailed(request *http.Request, email string) time.Duration {
+	now := time.Now()
+
+	retry_after := email_attempts.Fail(emailAttemptKey(email), now)
+	ip_retry_after := ip_attempts.Fail(ipAttemptKey(request), now)
+	if ip_retry_after > retry_after {
+		retry_after = ip_retry_after
+	}
+
+	return retry_after
+}
+
+// signInSucceeded forgets the failed sign-ins of email, once a session is
+// issued and not before the second factor.
+func signInSucceeded(email string) {
+	email_attempts.Reset(emailAttemptKey(email))
+}
+
+func setRetryAfter(writer http.ResponseWriter, retry_after time.Duration) {
+	seconds := int(retry_after / time.Second)
+	if retry_after%time.Second != 0 {
+		seconds++
+	}
+
+	writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+type UnlockPayload struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+// UnlockUser lets an admin lift the sign-in lockout of an email early. The
+// lockout of the client addresses it was tried from is left to expire: an
+// address counts the failures of every email tried from it, a guesser
+// spreading over many emails would otherwise be unlocked along with one of
+// them.
+func UnlockUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Content-Type", "application/json")
+	response := response.New()
+
+	payload := UnlockPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		response.Message = "invalid payload"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	email_attempts.Reset(emailAttemptKey(payload.Email))
+
+	response.Message = "berhasil membuka kunci akun " + payload.Email
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(response.ToJson())
+}
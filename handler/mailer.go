@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"os"
+
+	"github.com/mmiftahrzki/go-rest-api/mailer"
+)
+
+var mailer_client mailer.IMailer = mailer.NewLogMailer(os.Stdout)
+
+func SetMailer(m mailer.IMailer) {
+	mailer_client = m
+}
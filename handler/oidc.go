@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/oidc"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const oidc_state_cookie string = "oidc_state"
+
+var oidc_provider *oidc.Provider
+
+func SetOidcProvider(provider *oidc.Provider) {
+	oidc_provider = provider
+}
+
+// OidcLogin sends the browser to the identity provider. The state is also
+// kept in a cookie so the callback only accepts the browser that started.
+func OidcLogin(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	writer.Header().Set("Content-Type", "application/json")
+
+	auth_code_url, state, err := oidc_provider.Begin(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		response.Message = "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidc_state_cookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(writer, request, auth_code_url, http.StatusFound)
+}
+
+func OidcCallback(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	query := request.URL.Query()
+	if query.Get("error") != "" {
+		message = "login dengan penyedia identitas gagal: " + query.Get("error")
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := request.Cookie(oidc_state_cookie)
+	if err != nil || state == "" || cookie.Value != state {
+		message = "state tidak valid, silakan ulangi login"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidc_state_cookie,
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	claims, err := oidc_provider.Finish(request.Context(), state, query.Get("code"))
+	if err != nil {
+		if errors.Is(err, oidc.ErrUnknownState) {
+			message = "state tidak valid, silakan ulangi login"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		log.Println(err)
+
+		message = "login dengan penyedia identitas gagal"
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		message = "email dari penyedia identitas belum diverifikasi"
+		status_code = http.StatusForbidden
+
+		return
+	}
+
+	user, err := findOrCreateOidcUser(request.Context(), claims)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	sign_in_status, sign_in_message, err := completeSignIn(request, response.Data, user)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code, message = sign_in_status, sign_in_message
+}
+
+// findOrCreateOidcUser returns the user with the provider's verified email,
+// creating it on its first sign-in. The provider verified the email so the
+// account counts as verified. New accounts get an unknown random password,
+// one can be set through /api/auth/password/forgot.
+func findOrCreateOidcUser(ctx context.Context, claims *oidc.IdTokenClaims) (model.User, error) {
+	now := time.Now()
+
+	user, err := user_model.FindByEmail(ctx, claims.Email)
+	if err == nil {
+		if user.VerifiedAt == nil {
+			user.VerifiedAt = &now
+
+			return user_model.Update(ctx, user)
+		}
+
+		return user, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, err
+	}
+
+	random_password, err := randomPassword()
+	if err != nil {
+		return model.User{}, err
+	}
+
+	fullname := strings.TrimSpace(claims.Name)
+	if fullname == "" {
+		fullname = claims.Email
+	}
+
+	user, err = user_model.Insert(ctx, model.User{
+		Email:      claims.Email,
+		Password:   random_password,
+		Fullname:   fullname,
+		Role:       rbac.RoleStaff,
+		VerifiedAt: &now,
+	})
+	if errors.Is(err, model.ErrUserExists) {
+		// a concurrent first sign-in created the user already
+		return user_model.FindByEmail(ctx, claims.Email)
+	}
+
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return createPersonalOrganization(ctx, user), nil
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/mailer"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const password_reset_lifetime time.Duration = time.Hour
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,max=32"`
+}
+
+// passwordResetUrl is where the mailed link points to. PASSWORD_RESET_URL
+// should be the front-end page that asks for the new password.
+func passwordResetUrl(token string) string {
+	base_url := os.Getenv("PASSWORD_RESET_URL")
+	if base_url == "" {
+		base_url = fmt.Sprintf("%s:%s/api/auth/password/reset", os.Getenv("BASE_URL"), os.Getenv("PORT"))
+	}
+
+	return base_url + "?token=" + url.QueryEscape(token)
+}
+
+// sendPasswordResetEmail mails a new password reset link to email, between
+// the given opening and closing paragraphs.
+func sendPasswordResetEmail(ctx context.Context, email, opening, closing string) error {
+	token, err := user_token_model.Insert(ctx, email, model.UserTokenPasswordReset, "", password_reset_lifetime)
+	if err != nil {
+		return err
+	}
+
+	return mailer_client.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset password",
+		Body: fmt.Sprintf(
+			"%s\n\nBuka link berikut dalam %d menit untuk membuat password baru:\n%s\n\n%s",
+			opening, int(password_reset_lifetime.Minutes()), passwordResetUrl(token), closing),
+	})
+}
+
+func ForgotPassword(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := ForgotPasswordPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	// the answer is the same whether the email is registered or not, so this
+	// endpoint cannot be used to find out who has an account
+	const sent_message = "jika email terdaftar, link untuk reset password telah dikirim"
+
+	_, err = user_model.FindByEmail(request.Context(), payload.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			status_code = http.StatusOK
+			message = sent_message
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	err = sendPasswordResetEmail(request.Context(), payload.Email, "Seseorang meminta reset password untuk akun Anda.", "Abaikan email ini jika Anda tidak memintanya.")
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = sent_message
+}
+
+func ResetPassword(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := ResetPasswordPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user_token, err := user_token_model.Consume(request.Context(), payload.Token, model.UserTokenPasswordReset)
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidUserToken) {
+			message = "token reset password tidak valid atau sudah kedaluwarsa"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), user_token.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			message = "token reset password tidak valid atau sudah kedaluwarsa"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	// following the mailed link proves the address too
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+
+	user.Password = payload.Password
+	_, err = user_model.Update(request.Context(), user)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	// whoever knew the old password must not stay signed in
+	err = auth.RevokeAllTokens(request.Context(), user_token.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mengubah password, silakan login kembali"
+}
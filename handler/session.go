@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type SessionView struct {
+	auth.Session
+	Current bool `json:"current"`
+}
+
+func ReadSessions(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	sessions, err := auth.ActiveSessions(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	session_views := make([]SessionView, 0, len(sessions))
+	for _, session := range sessions {
+		session_views = append(session_views, SessionView{
+			Session: session,
+			Current: session.Id.String() == claims.SessionId,
+		})
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mendapatkan data sesi"
+	response.Data["sessions"] = session_views
+}
+
+func EndSession(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	id, err := uuid.Parse(params.ByName("session_id"))
+	if err != nil {
+		message = "id tidak valid"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	err = auth.EndSession(request.Context(), claims.Email, id)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			message = "sesi tidak ditemukan"
+			status_code = http.StatusNotFound
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil mengakhiri sesi"
+}
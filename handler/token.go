@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func RefreshToken(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := RefreshPayload{}
+	json_decoder := json.NewDecoder(request.Body)
+	err := json_decoder.Decode(&payload)
+	if err != nil || payload.RefreshToken == "" {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	refresh_token, new_refresh_token, err := auth.RotateRefreshToken(request.Context(), payload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			log.Printf("refresh token reuse detected for %s, token family %s revoked", refresh_token.Email, refresh_token.FamilyId)
+		}
+
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			message = "refresh token invalid"
+			status_code = http.StatusUnauthorized
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	// roles and the organization are read again so a demotion or a switch
+	// takes effect on the next refresh
+	user, err := user_model.FindByEmail(request.Context(), refresh_token.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			message = "refresh token invalid"
+			status_code = http.StatusUnauthorized
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	if user.DeactivatedAt != nil {
+		message = account_deactivated_message
+		status_code = http.StatusForbidden
+
+		return
+	}
+
+	// the family id is the sid of the access token, its session must exist
+	// for the token to be accepted
+	_, err = auth.ResumeSession(request.Context(), refresh_token.Email, refresh_token.FamilyId, request.UserAgent(), clientIp(request))
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			message = "refresh token invalid"
+			status_code = http.StatusUnauthorized
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	token, err := auth.GenerateSessionToken(refresh_token.Email, []string{user.Role}, organizationIdOf(user), refresh_token.FamilyId)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil generate token"
+	response.Data["token"] = token
+	response.Data["refresh_token"] = new_refresh_token
+}
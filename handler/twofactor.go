@@ -0,0 +1,430 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+	"github.com/mmiftahrzki/go-rest-api/totp"
+)
+
+const recovery_code_count int = 10
+
+type TwoFactorPayload struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. A TOTP code is recorded as used so it cannot be replayed.
+func verifySecondFactor(ctx context.Context, user model.User, code, recovery_code string) (bool, error) {
+	if recovery_code != "" {
+		return recovery_code_model.Consume(ctx, user.Id, auth.HashOpaqueToken(totp.NormalizeRecoveryCode(recovery_code)))
+	}
+
+	if user.TwoFactorSecret == "" || code == "" {
+		return false, nil
+	}
+
+	secret, err := totp.DecryptSecret(user.TwoFactorSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), user.TwoFactorLastStep)
+	if !ok {
+		return false, nil
+	}
+
+	return user_model.UseTwoFactorStep(ctx, user.Id, step)
+}
+
+func generateRecoveryCodes(ctx context.Context, user_id uuid.UUID) ([]string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recovery_code_count)
+	if err != nil {
+		return nil, err
+	}
+
+	code_hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code_hashes = append(code_hashes, auth.HashOpaqueToken(totp.NormalizeRecoveryCode(code)))
+	}
+
+	err = recovery_code_model.Replace(ctx, user_id, code_hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// userClaims returns the claims of a signed-in user, refusing tokens issued
+// to OAuth clients and API keys, which must not manage the account of their
+// owner.
+func userClaims(request *http.Request) (*auth.JwtClaims, int, string) {
+	claims, err := auth.ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		return nil, http.StatusInternalServerError, "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+	}
+
+	if claims.IsDelegated() {
+		return nil, http.StatusForbidden, "endpoint ini hanya dapat digunakan oleh user"
+	}
+
+	return claims, http.StatusOK, ""
+}
+
+func EnrollTwoFactor(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if user.TwoFactorEnabledAt != nil {
+		message = "autentikasi dua faktor sudah aktif"
+		status_code = http.StatusConflict
+
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	encrypted_secret, err := totp.EncryptSecret(secret)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = user_model.SetTwoFactorSecret(request.Context(), user.Id, encrypted_secret)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "go-rest-api"
+	}
+
+	status_code = http.StatusOK
+	message = "scan provisioning_uri dengan aplikasi authenticator lalu konfirmasi dengan kode yang muncul"
+	response.Data["secret"] = secret
+	response.Data["provisioning_uri"] = totp.ProvisioningUri(issuer, claims.Email, secret)
+}
+
+func ConfirmTwoFactor(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	payload := TwoFactorPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil || payload.Code == "" {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if user.TwoFactorEnabledAt != nil {
+		message = "autentikasi dua faktor sudah aktif"
+		status_code = http.StatusConflict
+
+		return
+	}
+
+	if user.TwoFactorSecret == "" {
+		message = "lakukan enroll autentikasi dua faktor terlebih dahulu"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	ok, err := verifySecondFactor(request.Context(), user, payload.Code, "")
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if !ok {
+		message = "kode tidak valid"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	codes, err := generateRecoveryCodes(request.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = user_model.EnableTwoFactor(request.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "autentikasi dua faktor aktif. simpan recovery_codes, nilainya tidak akan ditampilkan lagi"
+	response.Data["recovery_codes"] = codes
+}
+
+func DisableTwoFactor(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	claims, claims_status, claims_message := userClaims(request)
+	if claims == nil {
+		status_code, message = claims_status, claims_message
+
+		return
+	}
+
+	payload := TwoFactorPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil || (payload.Code == "" && payload.RecoveryCode == "") {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if user.TwoFactorEnabledAt == nil {
+		message = "autentikasi dua faktor tidak aktif"
+		status_code = http.StatusConflict
+
+		return
+	}
+
+	ok, err := verifySecondFactor(request.Context(), user, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if !ok {
+		message = "kode tidak valid"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	err = user_model.DisableTwoFactor(request.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	err = recovery_code_model.DeleteAll(request.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "autentikasi dua faktor dinonaktifkan"
+}
+
+// VerifyTwoFactor is the second step of sign-in for users with 2fa enabled:
+// it exchanges the challenge token from /api/auth/signin and a TOTP or
+// recovery code for the usual token pair.
+func VerifyTwoFactor(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := TwoFactorPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err != nil || payload.ChallengeToken == "" || (payload.Code == "" && payload.RecoveryCode == "") {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	challenge, err := auth.ParseChallengeToken(request.Context(), payload.ChallengeToken, auth.PurposeTwoFactor)
+	if err != nil {
+		message = "challenge token tidak valid atau sudah kedaluwarsa"
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	retry_after := signInRetryAfter(request, challenge.Email)
+	if retry_after > 0 {
+		setRetryAfter(writer, retry_after)
+
+		message = "terlalu banyak percobaan login, silakan coba lagi nanti"
+		status_code = http.StatusTooManyRequests
+
+		return
+	}
+
+	failed := false
+	defer func() {
+		if !failed {
+			releaseSignIn(request, challenge.Email)
+		}
+	}()
+
+	user, err := user_model.FindByEmail(request.Context(), challenge.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			message = "challenge token tidak valid atau sudah kedaluwarsa"
+			status_code = http.StatusUnauthorized
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	if user.DeactivatedAt != nil {
+		message = account_deactivated_message
+		status_code = http.StatusForbidden
+
+		return
+	}
+
+	ok, err := verifySecondFactor(request.Context(), user, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	if !ok {
+		failed = true
+
+		retry_after := signInFailed(request, challenge.Email)
+		if retry_after > 0 {
+			setRetryAfter(writer, retry_after)
+		}
+
+		message = "kode tidak valid"
+		status_code = http.StatusUnauthorized
+
+		return
+	}
+
+	signInSucceeded(challenge.Email)
+
+	err = auth.RevokeToken(request.Context(), challenge)
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	token, refresh_token, err := issueTokens(request, user.Email, user.Role, organizationIdOf(user))
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	status_code = http.StatusOK
+	message = "berhasil generate token"
+	response.Data["token"] = token
+	response.Data["refresh_token"] = refresh_token
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyTwoFactor(t *testing.T, email, code string) (int, map[string]interface{}) {
+	t.Helper()
+
+	challenge_token, err := auth.GenerateChallengeToken(email, auth.PurposeTwoFactor)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(TwoFactorPayload{ChallengeToken: challenge_token, Code: code})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/auth/2fa", strings.NewReader(string(body)))
+	recorder := httptest.NewRecorder()
+
+	VerifyTwoFactor(recorder, request, nil)
+
+	result := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	return recorder.Code, result
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "secret")
+	t.Setenv("TOTP_ENCRYPTION_KEY", "totp secret")
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	encrypted_secret, err := totp.EncryptSecret(secret)
+	require.NoError(t, err)
+
+	enabled_at := time.Now()
+	deactivated_at := time.Now()
+
+	fake := newFakeUserModel(
+		model.User{Id: uuid.New(), Email: "budi@email.com", Role: "staff", TwoFactorEnabledAt: &enabled_at, TwoFactorSecret: encrypted_secret},
+		model.User{Id: uuid.New(), Email: "ani@email.com", Role: "staff", TwoFactorEnabledAt: &enabled_at, TwoFactorSecret: encrypted_secret, DeactivatedAt: &deactivated_at},
+	)
+
+	previous_user_model := user_model
+	SetUserModel(fake)
+	t.Cleanup(func() { SetUserModel(previous_user_model) })
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	require.NoError(t, err)
+
+	status_code, result := verifyTwoFactor(t, "budi@email.com", code)
+	require.Equal(t, http.StatusOK, status_code, result["message"])
+
+	data := result["data"].(map[string]interface{})
+	assert.NotEmpty(t, data["token"])
+	assert.NotEmpty(t, data["refresh_token"])
+
+	user, err := fake.FindByEmail(context.Background(), "budi@email.com")
+	require.NoError(t, err)
+	assert.Equal(t, step, user.TwoFactorLastStep)
+
+	t.Run("replayed code", func(t *testing.T) {
+		status_code, _ := verifyTwoFactor(t, "budi@email.com", code)
+		assert.Equal(t, http.StatusUnauthorized, status_code)
+	})
+
+	t.Run("deactivated user", func(t *testing.T) {
+		status_code, _ := verifyTwoFactor(t, "ani@email.com", code)
+		assert.Equal(t, http.StatusForbidden, status_code)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		status_code, _ := verifyTwoFactor(t, "nobody@email.com", code)
+		assert.Equal(t, http.StatusUnauthorized, status_code)
+	})
+}
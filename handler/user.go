@@ -2,29 +2,130 @@ package handler
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/go-sql-driver/mysql"
-	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
-	"github.com/mmiftahrzki/go-rest-api/database"
 	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
 	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/password"
 	"github.com/mmiftahrzki/go-rest-api/response"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// issueTokens starts a session for a user that completed sign-in and returns
+// its access and refresh token.
+func issueTokens(request *http.Request, email, role, organization_id string) (string, string, error) {
+	session, err := auth.StartSession(request.Context(), email, request.UserAgent(), clientIp(request))
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := auth.GenerateSessionToken(email, []string{role}, organization_id, session.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	refresh_token, err := auth.GenerateRefreshToken(request.Context(), email, session.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, refresh_token, nil
+}
+
+const account_deactivated_message string = "akun telah dinonaktifkan, silakan hubungi admin"
+
+var user_model model.IUserModel
+
+// organizationIdOf returns the id of the organization user works in, empty
+// when it works in none.
+func organizationIdOf(user model.User) string {
+	if user.OrganizationId == nil {
+		return ""
+	}
+
+	return user.OrganizationId.String()
+}
+
+func SetUserModel(m model.IUserModel) {
+	user_model = m
+}
+
+var organization_model model.IOrganizationModel
+
+func SetOrganizationModel(m model.IOrganizationModel) {
+	organization_model = m
+}
+
+var user_token_model model.IUserTokenModel
+
+func SetUserTokenModel(m model.IUserTokenModel) {
+	user_token_model = m
+}
+
+var recovery_code_model model.IRecoveryCodeModel
+
+func SetRecoveryCodeModel(m model.IRecoveryCodeModel) {
+	recovery_code_model = m
+}
+
+// createPersonalOrganization gives a new user an organization of its own to
+// work in. Signing up does not fail without one, the user can still create
+// or join one through /api/organizations.
+func createPersonalOrganization(ctx context.Context, user model.User) model.User {
+	organization, err := organization_model.Insert(ctx, user.Fullname, user.Email)
+	if err != nil {
+		log.Println(err)
+
+		return user
+	}
+
+	user.OrganizationId = &organization.Id
+
+	updated_user, err := user_model.Update(ctx, user)
+	if err != nil {
+		log.Println(err)
+
+		user.OrganizationId = nil
+
+		return user
+	}
+
+	return updated_user
+}
+
+// rehashPassword replaces a hash that Verify flagged as outdated. Failing to
+// do so does not fail the sign-in, it is tried again the next time.
+func rehashPassword(ctx context.Context, user model.User, plain_password string) {
+	err := user_model.Rehash(ctx, user, plain_password)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// randomPassword returns a password nobody knows, for accounts whose owner
+// has to set one through a password reset.
+func randomPassword() (string, error) {
+	random_bytes := make([]byte, 32)
+	_, err := rand.Read(random_bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random_bytes), nil
+}
+
 // func (c *controller) CreateUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 func CreateUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	writer.Header().Set("Content-Type", "application/json")
@@ -72,58 +173,22 @@ func CreateUser(writer http.ResponseWriter, request *http.Request, params httpro
 		return
 	}
 
-	loc, _ := time.LoadLocation("Asia/Jakarta")
-	id := uuid.New()
-	now := time.Now().In(loc)
-	hmac_sha256 := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET_KEY")))
-	hmac_sha256.Write([]byte(user.Password))
-
-	password_hash, err := bcrypt.GenerateFromPassword(hmac_sha256.Sum(nil), bcrypt.DefaultCost)
-	if err != nil {
-		log.Println(err)
-
-		response.Message = "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
-
-		writer.WriteHeader(http.StatusInternalServerError)
-		writer.Write(response.ToJson())
-
-		return
-	}
-
-	sql_query :=
-		`INSERT INTO
-			user (
-				id,
-				id_text,
-				email,
-				password,
-				fullname,
-				created_at
-			)
-		VALUES (
-			unhex(replace(?, '-', '')),
-			UPPER(?),
-			?,
-			?,
-			?,
-			?
-		);`
-
-	db := database.GetDatabaseConnection()
-
-	_, err = db.ExecContext(request.Context(), sql_query, id, id.String(), user.Email, string(password_hash), user.Fullname, now)
+	// role and verification are not up to the one signing up
+	new_user, err := user_model.Insert(request.Context(), model.User{
+		Email:    user.Email,
+		Password: user.Password,
+		Fullname: user.Fullname,
+		Role:     rbac.RoleStaff,
+	})
 	if err != nil {
-		log.Println(err)
-
 		response_status := http.StatusInternalServerError
 		response_message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
 
-		mysql_error, ok := err.(*mysql.MySQLError)
-		if ok {
-			if mysql_error.Number == 1062 {
-				response_status = http.StatusConflict
-				response_message = fmt.Sprintf("user dengan email: %s sudah ada", user.Email)
-			}
+		if errors.Is(err, model.ErrUserExists) {
+			response_status = http.StatusConflict
+			response_message = fmt.Sprintf("user dengan email: %s sudah ada", user.Email)
+		} else {
+			log.Println(err)
 		}
 
 		response.Message = response_message
@@ -134,8 +199,17 @@ func CreateUser(writer http.ResponseWriter, request *http.Request, params httpro
 		return
 	}
 
-	response.Message = "berhasil membuat user baru"
-	response.Data["id"] = id.String()
+	createPersonalOrganization(request.Context(), new_user)
+
+	// the account exists either way, a failed mail can be requested again
+	// through /api/auth/verify/resend
+	err = sendVerificationEmail(request.Context(), user.Email)
+	if err != nil {
+		log.Println(err)
+	}
+
+	response.Message = "berhasil membuat user baru, silakan cek email Anda untuk verifikasi"
+	response.Data["id"] = new_user.Id.String()
 
 	writer.WriteHeader(http.StatusCreated)
 	writer.Write(response.ToJson())
@@ -182,56 +256,115 @@ func ReadUser(writer http.ResponseWriter, request *http.Request, params httprout
 		return
 	}
 
-	hmac_sha256 := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET_KEY")))
-	hmac_sha256.Write([]byte(user_login.Password))
+	retry_after := signInRetryAfter(request, user_login.Email)
+	if retry_after > 0 {
+		setRetryAfter(writer, retry_after)
 
-	sql_query := "SELECT password FROM user WHERE email=?;"
-	db := database.GetDatabaseConnection()
-	row, err := db.QueryContext(request.Context(), sql_query, user_login.Email)
-	if err != nil {
-		log.Println(err)
+		message = "terlalu banyak percobaan login, silakan coba lagi nanti"
+		status_code = http.StatusTooManyRequests
 
 		return
 	}
-	defer row.Close()
 
-	if !row.Next() {
+	failed := false
+	defer func() {
+		if !failed {
+			releaseSignIn(request, user_login.Email)
+		}
+	}()
+
+	invalid_credentials := func() {
+		failed = true
+
+		retry_after := signInFailed(request, user_login.Email)
+		if retry_after > 0 {
+			setRetryAfter(writer, retry_after)
+		}
+
 		message = "email atau password invalid"
-		status_code = http.StatusOK
+		status_code = http.StatusUnauthorized
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), user_login.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// spend the same time as a wrong password so unknown emails cannot be told apart
+			password.VerifyDummy(user_login.Password)
+			invalid_credentials()
+
+			return
+		}
+
+		log.Println(err)
 
 		return
 	}
 
-	var stored_hashed_password []byte
-	err = row.Scan(&stored_hashed_password)
+	ok, needs_rehash, err := password.Verify(user_login.Password, user.PasswordHash)
 	if err != nil {
 		log.Println(err)
 
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword(stored_hashed_password, hmac_sha256.Sum(nil))
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			message = "email atau password invalid"
-			status_code = http.StatusOK
+	if !ok {
+		invalid_credentials()
 
-			return
-		}
+		return
+	}
 
-		log.Println(err)
+	if needs_rehash {
+		rehashPassword(request.Context(), user, user_login.Password)
+	}
+
+	if user.VerifiedAt == nil {
+		message = "email belum diverifikasi, silakan cek email Anda"
+		status_code = http.StatusForbidden
 
 		return
 	}
 
-	token, err := auth.GenerateToken(*user_login)
+	sign_in_status, sign_in_message, err := completeSignIn(request, response.Data, user)
 	if err != nil {
 		log.Println(err)
 
 		return
 	}
 
-	status_code = http.StatusOK
-	message = "berhasil generate token"
-	response.Data["token"] = token
+	status_code, message = sign_in_status, sign_in_message
+}
+
+// completeSignIn answers a sign-in whose first factor succeeded: a challenge
+// token when 2fa is enabled, a new session otherwise. The tokens are put
+// into data. Deactivated users are turned away. Failed sign-ins of the email
+// are only forgotten with the session, a correct password alone must not
+// give a second factor guesser more attempts.
+func completeSignIn(request *http.Request, data map[string]interface{}, user model.User) (int, string, error) {
+	if user.DeactivatedAt != nil {
+		return http.StatusForbidden, account_deactivated_message, nil
+	}
+
+	if user.TwoFactorEnabledAt != nil {
+		challenge_token, err := auth.GenerateChallengeToken(user.Email, auth.PurposeTwoFactor)
+		if err != nil {
+			return 0, "", err
+		}
+
+		data["two_factor_required"] = true
+		data["challenge_token"] = challenge_token
+
+		return http.StatusOK, "masukkan kode autentikasi dua faktor di /api/auth/2fa", nil
+	}
+
+	token, refresh_token, err := issueTokens(request, user.Email, user.Role, organizationIdOf(user))
+	if err != nil {
+		return 0, "", err
+	}
+
+	signInSucceeded(user.Email)
+
+	data["token"] = token
+	data["refresh_token"] = refresh_token
+
+	return http.StatusOK, "berhasil generate token", nil
 }
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/mailer"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/password"
+	"github.com/mmiftahrzki/go-rest-api/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUserModel keeps users in memory. Methods the tests do not reach are
+// left to the embedded nil interface and panic.
+type fakeUserModel struct {
+	model.IUserModel
+
+	mutex sync.Mutex
+	users map[string]model.User
+	// insert_err is returned by Insert when set.
+	insert_err error
+}
+
+func newFakeUserModel(users ...model.User) *fakeUserModel {
+	fake := &fakeUserModel{users: map[string]model.User{}}
+	for _, user := range users {
+		fake.users[user.Email] = user
+	}
+
+	return fake
+}
+
+func (fake *fakeUserModel) Insert(ctx context.Context, user model.User) (model.User, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if fake.insert_err != nil {
+		return model.User{}, fake.insert_err
+	}
+
+	_, ok := fake.users[user.Email]
+	if ok {
+		return model.User{}, model.ErrUserExists
+	}
+
+	password_hash, err := password.Hash(user.Password)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user.Id = uuid.New()
+	user.Password = ""
+	user.PasswordHash = password_hash
+	user.CreatedAt = time.Now()
+	fake.users[user.Email] = user
+
+	return user, nil
+}
+
+func (fake *fakeUserModel) Update(ctx context.Context, user model.User) (model.User, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	_, ok := fake.users[user.Email]
+	if !ok {
+		return model.User{}, sql.ErrNoRows
+	}
+
+	fake.users[user.Email] = user
+
+	return user, nil
+}
+
+func (fake *fakeUserModel) FindByEmail(ctx context.Context, email string) (model.User, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	user, ok := fake.users[email]
+	if !ok {
+		return user, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (fake *fakeUserModel) UseTwoFactorStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for email, user := range fake.users {
+		if user.Id != id {
+			continue
+		}
+
+		if user.TwoFactorLastStep >= step {
+			return false, nil
+		}
+
+		user.TwoFactorLastStep = step
+		fake.users[email] = user
+
+		return true, nil
+	}
+
+	return false, sql.ErrNoRows
+}
+
+type fakeOrganizationModel struct {
+	model.IOrganizationModel
+}
+
+func (fake *fakeOrganizationModel) Insert(ctx context.Context, name, creator_email string) (model.Organization, error) {
+	return model.Organization{Id: uuid.New(), Name: name, CreatedBy: creator_email}, nil
+}
+
+type fakeUserToken struct {
+	email      string
+	purpose    string
+	created_at time.Time
+}
+
+// fakeUserTokenModel remembers every token it hands out.
+type fakeUserTokenModel struct {
+	model.IUserTokenModel
+
+	mutex  sync.Mutex
+	tokens []fakeUserToken
+}
+
+func (fake *fakeUserTokenModel) Insert(ctx context.Context, email, purpose, data string, lifetime time.Duration) (string, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.tokens = append(fake.tokens, fakeUserToken{email: email, purpose: purpose, created_at: time.Now()})
+
+	return uuid.NewString(), nil
+}
+
+func (fake *fakeUserTokenModel) CountSince(ctx context.Context, email, purpose string, since time.Time) (int, time.Time, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	count := 0
+	last_created_at := time.Time{}
+
+	for _, token := range fake.tokens {
+		if token.email != email || token.purpose != purpose || token.created_at.Before(since) {
+			continue
+		}
+
+		count++
+		if token.created_at.After(last_created_at) {
+			last_created_at = token.created_at
+		}
+	}
+
+	return count, last_created_at, nil
+}
+
+// purposes returns the purposes of the tokens handed out to email.
+func (fake *fakeUserTokenModel) purposes(email string) []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	purposes := []string{}
+	for _, token := range fake.tokens {
+		if token.email == email {
+			purposes = append(purposes, token.purpose)
+		}
+	}
+
+	return purposes
+}
+
+// setUpUserHandlers points the handlers at fakes holding users and restores
+// what was there when the test ends.
+func setUpUserHandlers(t *testing.T, users ...model.User) (*fakeUserModel, *fakeUserTokenModel) {
+	t.Helper()
+
+	t.Setenv("JWT_SECRET_KEY", "secret")
+	t.Setenv("PASSWORD_PEPPERS", "1:pepper")
+	t.Setenv("PASSWORD_PEPPER_ID", "1")
+	t.Setenv("PASSWORD_ALGORITHM", "")
+	t.Setenv("PASSWORD_BCRYPT_COST", "4")
+	require.NoError(t, password.Load())
+
+	for i := range users {
+		password_hash, err := password.Hash(users[i].Password)
+		require.NoError(t, err)
+
+		users[i].Password = ""
+		users[i].PasswordHash = password_hash
+	}
+
+	fake_user_model := newFakeUserModel(users...)
+	fake_user_token_model := &fakeUserTokenModel{}
+
+	previous_user_model := user_model
+	previous_organization_model := organization_model
+	previous_user_token_model := user_token_model
+	previous_mailer_client := mailer_client
+	previous_email_attempts, previous_ip_attempts := email_attempts, ip_attempts
+
+	SetUserModel(fake_user_model)
+	SetOrganizationModel(&fakeOrganizationModel{})
+	SetUserTokenModel(fake_user_token_model)
+	SetMailer(mailer.NewLogMailer(io.Discard))
+	SetAttemptStores(
+		throttle.NewMemoryAttemptStore(throttle.Policy{FreeAttempts: 100, LockoutAfter: 100, Window: time.Hour}),
+		throttle.NewMemoryAttemptStore(throttle.Policy{FreeAttempts: 100, LockoutAfter: 100, Window: time.Hour}),
+	)
+
+	t.Cleanup(func() {
+		SetUserModel(previous_user_model)
+		SetOrganizationModel(previous_organization_model)
+		SetUserTokenModel(previous_user_token_model)
+		SetMailer(previous_mailer_client)
+		SetAttemptStores(previous_email_attempts, previous_ip_attempts)
+	})
+
+	return fake_user_model, fake_user_token_model
+}
+
+func serveUserHandler(t *testing.T, handle func(http.ResponseWriter, *http.Request, httprouter.Params), path, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, request, nil)
+
+	result := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	return recorder.Code, result
+}
+
+func TestCreateUser(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		body       string
+		insert_err error
+		status     int
+	}{
+		{"invalid payload", `{"email":"budi"}`, nil, http.StatusBadRequest},
+		{"email taken", `{"email":"ani@email.com","password":"rahasia","fullname":"Ani"}`, nil, http.StatusConflict},
+		{"insert error", `{"email":"budi@email.com","password":"rahasia","fullname":"Budi"}`, errors.New("connection refused"), http.StatusInternalServerError},
+		{"success", `{"email":"budi@email.com","password":"rahasia","fullname":"Budi","role":"admin"}`, nil, http.StatusCreated},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake_user_model, fake_user_token_model := setUpUserHandlers(t, model.User{Id: uuid.New(), Email: "ani@email.com", Password: "rahasia", Role: "staff"})
+			fake_user_model.insert_err = test.insert_err
+
+			status_code, result := serveUserHandler(t, CreateUser, "/api/auth/signup", test.body)
+			require.Equal(t, test.status, status_code, result)
+
+			if status_code != http.StatusCreated {
+				assert.NotContains(t, fake_user_model.users, "budi@email.com")
+				assert.Empty(t, fake_user_token_model.tokens)
+
+				return
+			}
+
+			user := fake_user_model.users["budi@email.com"]
+			data := result["data"].(map[string]interface{})
+			assert.Equal(t, user.Id.String(), data["id"])
+
+			// role and verification are not up to the one signing up
+			assert.Equal(t, "staff", user.Role)
+			assert.Nil(t, user.VerifiedAt)
+			assert.NotNil(t, user.OrganizationId)
+			assert.Equal(t, []string{model.UserTokenEmailVerification}, fake_user_token_model.purposes("budi@email.com"))
+		})
+	}
+}
+
+func TestReadUser(t *testing.T) {
+	now := time.Now()
+
+	for _, test := range []struct {
+		name   string
+		body   string
+		status int
+		data   []string
+	}{
+		{"invalid payload", `{"email":}`, http.StatusBadRequest, nil},
+		{"unknown email", `{"email":"siapa@email.com","password":"rahasia"}`, http.StatusUnauthorized, nil},
+		{"wrong password", `{"email":"budi@email.com","password":"salah"}`, http.StatusUnauthorized, nil},
+		{"unverified", `{"email":"cici@email.com","password":"rahasia"}`, http.StatusForbidden, nil},
+		{"deactivated", `{"email":"dedi@email.com","password":"rahasia"}`, http.StatusForbidden, nil},
+		{"two factor", `{"email":"eka@email.com","password":"rahasia"}`, http.StatusOK, []string{"two_factor_required", "challenge_token"}},
+		{"success", `{"email":"budi@email.com","password":"rahasia"}`, http.StatusOK, []string{"token", "refresh_token"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setUpUserHandlers(t,
+				model.User{Id: uuid.New(), Email: "budi@email.com", Password: "rahasia", Role: "staff", VerifiedAt: &now},
+				model.User{Id: uuid.New(), Email: "cici@email.com", Password: "rahasia", Role: "staff"},
+				model.User{Id: uuid.New(), Email: "dedi@email.com", Password: "rahasia", Role: "staff", VerifiedAt: &now, DeactivatedAt: &now},
+				model.User{Id: uuid.New(), Email: "eka@email.com", Password: "rahasia", Role: "staff", VerifiedAt: &now, TwoFactorEnabledAt: &now},
+			)
+
+			status_code, result := serveUserHandler(t, ReadUser, "/api/auth/signin", test.body)
+			require.Equal(t, test.status, status_code, result)
+
+			data, _ := result["data"].(map[string]interface{})
+			for _, key := range test.data {
+				assert.NotEmpty(t, data[key], key)
+			}
+
+			if test.data == nil {
+				assert.Empty(t, data)
+			}
+		})
+	}
+}
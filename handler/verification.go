@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/mailer"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const email_verification_lifetime time.Duration = 24 * time.Hour
+const verification_resend_interval time.Duration = time.Minute
+const verification_resend_window time.Duration = time.Hour
+const verification_resend_max int = 5
+
+type VerifyPayload struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationPayload struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+func verificationUrl(token string) string {
+	return fmt.Sprintf("%s:%s/api/auth/verify?token=%s", os.Getenv("BASE_URL"), os.Getenv("PORT"), url.QueryEscape(token))
+}
+
+func sendVerificationEmail(ctx context.Context, email string) error {
+	token, err := user_token_model.Insert(ctx, email, model.UserTokenEmailVerification, "", email_verification_lifetime)
+	if err != nil {
+		return err
+	}
+
+	return mailer_client.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verifikasi email",
+		Body: fmt.Sprintf(
+			"Terima kasih telah mendaftar.\n\nBuka link berikut dalam %d jam untuk memverifikasi email Anda:\n%s",
+			int(email_verification_lifetime.Hours()), verificationUrl(token)),
+	})
+}
+
+// VerifyEmail accepts the token either as the ?token= query parameter of the
+// mailed link or as a JSON body.
+func VerifyEmail(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := VerifyPayload{Token: request.URL.Query().Get("token")}
+	if payload.Token == "" && request.Method == http.MethodPost {
+		json.NewDecoder(request.Body).Decode(&payload)
+	}
+
+	if payload.Token == "" {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	user_token, err := user_token_model.Consume(request.Context(), payload.Token, model.UserTokenEmailVerification, model.UserTokenEmailChange)
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidUserToken) {
+			message = "token verifikasi tidak valid atau sudah kedaluwarsa"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	if user_token.Purpose == model.UserTokenEmailChange {
+		err = confirmEmailChange(request.Context(), user_token.Email, user_token.Data)
+		if err != nil {
+			if errors.Is(err, model.ErrUserExists) {
+				message = fmt.Sprintf("user dengan email: %s sudah ada", user_token.Data)
+				status_code = http.StatusConflict
+
+				return
+			}
+
+			if errors.Is(err, sql.ErrNoRows) {
+				message = "token verifikasi tidak valid atau sudah kedaluwarsa"
+				status_code = http.StatusBadRequest
+
+				return
+			}
+
+			log.Println(err)
+
+			return
+		}
+
+		status_code = http.StatusOK
+		message = "email berhasil diubah, silakan login kembali dengan email baru"
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), user_token.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			message = "token verifikasi tidak valid atau sudah kedaluwarsa"
+			status_code = http.StatusBadRequest
+
+			return
+		}
+
+		log.Println(err)
+
+		return
+	}
+
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		user.VerifiedAt = &now
+
+		_, err = user_model.Update(request.Context(), user)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+	}
+
+	status_code = http.StatusOK
+	message = "email berhasil diverifikasi"
+}
+
+// ResendVerification mails the verification link again to a registered email
+// that is not verified yet. It answers 202 whatever the email, limited or
+// not, so the response does not tell which emails are registered. The limit
+// is counted for the submitted email before it is looked up.
+func ResendVerification(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	status_code := http.StatusInternalServerError
+	message := "terjadi kesalahan tak terduga di server. silakan coba lagi nanti."
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	defer func() {
+		response.Message = message
+
+		writer.WriteHeader(status_code)
+		writer.Write(response.ToJson())
+	}()
+
+	payload := ResendVerificationPayload{}
+	err := json.NewDecoder(request.Body).Decode(&payload)
+	if err == nil {
+		err = validator.New().Struct(payload)
+	}
+
+	if err != nil {
+		message = "invalid payload"
+		status_code = http.StatusBadRequest
+
+		return
+	}
+
+	const sent_message = "jika email terdaftar dan belum diverifikasi, email verifikasi telah dikirim"
+
+	now := time.Now()
+	sent_count, last_sent_at, err := user_token_model.CountSince(request.Context(), payload.Email, model.UserTokenEmailVerification, now.Add(-verification_resend_window))
+	if err != nil {
+		log.Println(err)
+
+		return
+	}
+
+	// a 429 would only ever reach registered emails, the limited request is
+	// answered like any other
+	if sent_count >= verification_resend_max || (sent_count > 0 && now.Sub(last_sent_at) < verification_resend_interval) {
+		status_code = http.StatusAccepted
+		message = sent_message
+
+		return
+	}
+
+	user, err := user_model.FindByEmail(request.Context(), payload.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+
+		return
+	}
+
+	if err == nil && user.VerifiedAt == nil {
+		err = sendVerificationEmail(request.Context(), payload.Email)
+		if err != nil {
+			log.Println(err)
+
+			return
+		}
+	}
+
+	status_code = http.StatusAccepted
+	message = sent_message
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResendVerification makes sure the response does not tell registered
+// emails apart, limited or not.
+func TestResendVerification(t *testing.T) {
+	now := time.Now()
+
+	_, fake_user_token_model := setUpUserHandlers(t,
+		model.User{Id: uuid.New(), Email: "budi@email.com", Password: "rahasia", Role: "staff"},
+		model.User{Id: uuid.New(), Email: "ani@email.com", Password: "rahasia", Role: "staff", VerifiedAt: &now},
+	)
+
+	resend := func(email string) {
+		t.Helper()
+
+		status_code, result := serveUserHandler(t, ResendVerification, "/api/auth/verify/resend", `{"email":"`+email+`"}`)
+		require.Equal(t, http.StatusAccepted, status_code, result)
+		assert.Equal(t, map[string]interface{}{"message": "jika email terdaftar dan belum diverifikasi, email verifikasi telah dikirim"}, result)
+	}
+
+	for _, email := range []string{"budi@email.com", "ani@email.com", "siapa@email.com"} {
+		resend(email)
+	}
+
+	assert.Equal(t, []string{model.UserTokenEmailVerification}, fake_user_token_model.purposes("budi@email.com"))
+	assert.Empty(t, fake_user_token_model.purposes("ani@email.com"))
+	assert.Empty(t, fake_user_token_model.purposes("siapa@email.com"))
+
+	// within verification_resend_interval nothing is sent, known or not
+	for _, email := range []string{"budi@email.com", "siapa@email.com"} {
+		resend(email)
+	}
+
+	assert.Len(t, fake_user_token_model.purposes("budi@email.com"), 1)
+
+	// once the interval passed another link is sent, up to
+	// verification_resend_max in verification_resend_window
+	fake_user_token_model.mutex.Lock()
+	fake_user_token_model.tokens[0].created_at = now.Add(-verification_resend_interval)
+	fake_user_token_model.mutex.Unlock()
+
+	resend("budi@email.com")
+	assert.Len(t, fake_user_token_model.purposes("budi@email.com"), 2)
+
+	fake_user_token_model.mutex.Lock()
+	for i := range fake_user_token_model.tokens {
+		fake_user_token_model.tokens[i].created_at = now.Add(-2 * verification_resend_interval)
+	}
+	for len(fake_user_token_model.tokens) < verification_resend_max {
+		fake_user_token_model.tokens = append(fake_user_token_model.tokens, fake_user_token_model.tokens[0])
+	}
+	fake_user_token_model.mutex.Unlock()
+
+	resend("budi@email.com")
+	assert.Len(t, fake_user_token_model.purposes("budi@email.com"), verification_resend_max)
+}
@@ -0,0 +1,116 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type IMailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewFromEnv picks the mailer named by MAILER: "smtp", "file" or "log"
+// (the default, which prints messages to stdout).
+func NewFromEnv() IMailer {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return NewSmtpMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+	case "file":
+		return NewFileMailer(os.Getenv("MAILER_DIR"))
+	}
+
+	return NewLogMailer(os.Stdout)
+}
+
+type logMailer struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewLogMailer(writer io.Writer) IMailer {
+	return &logMailer{writer: writer}
+}
+
+func (mailer *logMailer) Send(ctx context.Context, message Message) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	_, err := fmt.Fprintf(mailer.writer, "To: %s\nSubject: %s\n\n%s\n\n", message.To, message.Subject, message.Body)
+
+	return err
+}
+
+type fileMailer struct {
+	dir string
+}
+
+// NewFileMailer writes every message to its own file in dir, which makes the
+// links it contains easy to pick up locally and in tests.
+func NewFileMailer(dir string) IMailer {
+	if dir == "" {
+		dir = "mail"
+	}
+
+	return &fileMailer{dir: dir}
+}
+
+func (mailer *fileMailer) Send(ctx context.Context, message Message) error {
+	err := os.MkdirAll(mailer.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(message.To)
+	file_name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), recipient)
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", message.To, message.Subject, message.Body)
+
+	return os.WriteFile(filepath.Join(mailer.dir, file_name), []byte(content), 0o600)
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSmtpMailer(addr, username, password, from string) IMailer {
+	var smtp_auth smtp.Auth
+
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i > 0 {
+			host = addr[:i]
+		}
+
+		smtp_auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpMailer{addr: addr, auth: smtp_auth, from: from}
+}
+
+func (mailer *smtpMailer) Send(ctx context.Context, message Message) error {
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", mailer.from, message.To, message.Subject, message.Body)
+
+	err := smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{message.To}, []byte(content))
+	if err != nil {
+		log.Println(err)
+
+		return err
+	}
+
+	return nil
+}
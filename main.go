@@ -1,31 +1,37 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
 	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/audit"
 	"github.com/mmiftahrzki/go-rest-api/controller"
 	"github.com/mmiftahrzki/go-rest-api/database"
 	"github.com/mmiftahrzki/go-rest-api/handler"
+	"github.com/mmiftahrzki/go-rest-api/mailer"
 	"github.com/mmiftahrzki/go-rest-api/middleware"
 	auth_pkg "github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/cors"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
 	"github.com/mmiftahrzki/go-rest-api/middleware/validation"
 	"github.com/mmiftahrzki/go-rest-api/model"
+	"github.com/mmiftahrzki/go-rest-api/oidc"
+	"github.com/mmiftahrzki/go-rest-api/openapi"
+	"github.com/mmiftahrzki/go-rest-api/password"
 	router_pkg "github.com/mmiftahrzki/go-rest-api/router"
 )
 
 //go:embed docs/swagger-ui.html
 var swagger_ui_html []byte
 
-//go:embed swagger.yaml
-var swagger_yaml []byte
-
 //go:embed index.html
 var index_html []byte
 
@@ -38,45 +44,231 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	err = auth_pkg.LoadKeys()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// tokens issued right after a logout-all or a password change must not
+	// fall in the revoked second, the dates of every token become fractional
+	auth_pkg.UseMillisecondTimestamps()
+
+	err = password.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	db := database.GetDatabaseConnection()
 	defer db.Close()
 
+	auth_pkg.SetRefreshTokenStore(auth_pkg.NewMySQLRefreshTokenStore(db, "refresh_token"))
+	auth_pkg.SetRevocationStore(auth_pkg.NewMySQLRevocationStore(db, "revoked_token", "token_revocation_cutoff"))
+	auth_pkg.SetSessionStore(auth_pkg.NewMySQLSessionStore(db, "user_session"))
+	audit.SetStore(audit.NewMySQLStore(db, "audit_log"))
+	handler.SetMailer(mailer.NewFromEnv())
+	model_user := model.NewUser(db, "user", model.UserTables{
+		Customer:           "customer",
+		CustomerShare:      "customer_share",
+		CustomerTransfer:   "customer_transfer",
+		ApiKey:             "api_key",
+		OauthClient:        "oauth_client",
+		UserToken:          "user_token",
+		RecoveryCode:       "user_recovery_code",
+		Organization:       "organization",
+		OrganizationMember: "organization_member",
+	})
+	model_organization := model.NewOrganization(db, "organization", "organization_member")
+	handler.SetUserModel(model_user)
+	handler.SetOrganizationModel(model_organization)
+	handler.SetUserTokenModel(model.NewUserToken(db, "user_token"))
+	handler.SetRecoveryCodeModel(model.NewRecoveryCode(db, "user_recovery_code"))
+
+	oidc_config, oidc_enabled := oidc.ConfigFromEnv()
+
+	if oidc_enabled {
+		oidc_provider, err := oidc.NewProvider(context.Background(), oidc_config)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		handler.SetOidcProvider(oidc_provider)
+	}
+
 	model_customer := model.NewCustomer(db, "customer")
 	controller_customer := controller.NewCustomer(model_customer)
+	model_client := model.NewClient(db, "oauth_client", "oauth_token_log")
+	controller_client := controller.NewClient(model_client)
+	model_api_key := model.NewApiKey(db, "api_key")
+	controller_api_key := controller.NewApiKey(model_api_key)
+	controller_organization := controller.NewOrganization(model_organization, model_user)
 	router := router_pkg.New()
 
+	auth_pkg.SetApiKeyStore(model_api_key)
+
 	auth := auth_pkg.New()
 	customerValidation := validation.New()
 
+	// without CORS_ALLOWED_ORIGINS no origin is allowed, the middleware then
+	// only answers preflights with the allowed methods
+	cors_config, cors_enabled := cors.ConfigFromEnv()
+
+	corsPolicy, err := cors.New(cors_config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if cors_enabled {
+		log.Println("CORS is enabled for:", strings.Join(cors_config.AllowedOrigins, ", "))
+	}
+
 	helloWorld := router_pkg.Endpoint{Path: "/", Method: http.MethodGet}
 
-	signUp := router_pkg.Endpoint{Path: "/api/auth/signup", Method: http.MethodPost}
-	signIn := router_pkg.Endpoint{Path: "/api/auth/signin", Method: http.MethodPost}
+	tokens := map[string]interface{}{"token": "", "refresh_token": ""}
+
+	authRoutes := router.Group("/api/auth").Preflight(corsPolicy).Describe([]string{"auth"})
+	signUp := router_pkg.Endpoint{Path: "/signup", Method: http.MethodPost, Summary: "Add a new user to the service", Request: model.User{}, Response: map[string]interface{}{"id": ""}, Status: http.StatusCreated}
+	signIn := router_pkg.Endpoint{Path: "/signin", Method: http.MethodPost, Summary: "Sign in with email and password", Request: auth_pkg.SignInPayload{}, Response: map[string]interface{}{"token": "", "refresh_token": "", "two_factor_required": false, "challenge_token": ""}}
+	refreshToken := router_pkg.Endpoint{Path: "/refresh", Method: http.MethodPost, Summary: "Exchange a refresh token for new tokens", Request: handler.RefreshPayload{}, Response: tokens}
+	logout := router_pkg.Endpoint{Path: "/logout", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Security: []string{openapi.SecurityBearer}, Summary: "Revoke the token in use", Request: auth_pkg.LogoutPayload{}}
+	logoutAll := router_pkg.Endpoint{Path: "/logout-all", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Security: []string{openapi.SecurityBearer}, Summary: "Revoke every token of the user"}
+	forgotPassword := router_pkg.Endpoint{Path: "/password/forgot", Method: http.MethodPost, Summary: "Mail a password reset link", Request: handler.ForgotPasswordPayload{}}
+	resetPassword := router_pkg.Endpoint{Path: "/password/reset", Method: http.MethodPost, Summary: "Set a new password with a reset token", Request: handler.ResetPasswordPayload{}}
+	verifyEmailLink := router_pkg.Endpoint{Path: "/verify", Method: http.MethodGet, Summary: "Verify an email from the mailed link"}
+	verifyEmail := router_pkg.Endpoint{Path: "/verify", Method: http.MethodPost, Summary: "Verify an email", Request: handler.VerifyPayload{}}
+	resendVerification := router_pkg.Endpoint{Path: "/verify/resend", Method: http.MethodPost, Summary: "Mail the verification link again", Request: handler.ResendVerificationPayload{}, Status: http.StatusAccepted}
+	twoFactor := router_pkg.Endpoint{Path: "/2fa", Method: http.MethodPost, Summary: "Finish signing in with a 2FA code", Request: handler.TwoFactorPayload{}, Response: tokens}
+	enrollTwoFactor := router_pkg.Endpoint{Path: "/2fa/enroll", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Security: []string{openapi.SecurityBearer}, Summary: "Start enrolling in 2FA", Response: map[string]interface{}{"secret": "", "provisioning_uri": ""}}
+	confirmTwoFactor := router_pkg.Endpoint{Path: "/2fa/confirm", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Security: []string{openapi.SecurityBearer}, Summary: "Turn 2FA on", Request: handler.TwoFactorPayload{}, Response: map[string]interface{}{"recovery_codes": []string{}}}
+	disableTwoFactor := router_pkg.Endpoint{Path: "/2fa/disable", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Security: []string{openapi.SecurityBearer}, Summary: "Turn 2FA off", Request: handler.TwoFactorPayload{}}
+	oidcLogin := router_pkg.Endpoint{Path: "/oidc/login", Method: http.MethodGet, Summary: "Redirect to the OIDC identity provider"}
+	oidcCallback := router_pkg.Endpoint{Path: "/oidc/callback", Method: http.MethodGet, Summary: "Finish signing in with OIDC", Response: tokens}
+	unlockUser := router_pkg.Endpoint{Path: "/unlock", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth}, Permissions: []string{rbac.PermUsersAdmin}, Security: []string{openapi.SecurityBearer}, Summary: "Lift the sign-in lockout of an email, not of the addresses it was tried from", Request: handler.UnlockPayload{}}
+	// /token takes a form and answers in the OAuth 2.0 format, not in the
+	// envelope of package response
+	getToken := router_pkg.Endpoint{Path: "/token", Method: http.MethodPost, Summary: "Issue an OAuth 2.0 client credentials token"}
+	devToken := router_pkg.Endpoint{Path: "/dev-token", Method: http.MethodPost, Summary: "Issue a token without a password, dev mode only", Request: auth_pkg.DevTokenPayload{}, Response: map[string]interface{}{"token": ""}}
+
+	userRoutes := router.Group("/api/users", auth).Preflight(corsPolicy).Describe([]string{"user"}, openapi.SecurityBearer)
+	getAllUsers := router_pkg.Endpoint{Method: http.MethodGet, Permissions: []string{rbac.PermUsersAdmin}, Summary: "returns users list", Response: map[string]interface{}{"users": []model.User{}, "__next": ""}}
+	getUserById := router_pkg.Endpoint{Path: "/:id", Method: http.MethodGet, Permissions: []string{rbac.PermUsersAdmin}, Summary: "returns a user", Response: map[string]interface{}{"user": model.User{}}}
+	deleteUser := router_pkg.Endpoint{Path: "/:id", Method: http.MethodDelete, Permissions: []string{rbac.PermUsersAdmin}, Summary: "Delete a user", Response: map[string]interface{}{"customer_count": 0}}
+	deactivateUser := router_pkg.Endpoint{Path: "/:id/deactivate", Method: http.MethodPost, Permissions: []string{rbac.PermUsersAdmin}, Summary: "Deactivate a user", Response: map[string]interface{}{"user": model.User{}}}
+	reactivateUser := router_pkg.Endpoint{Path: "/:id/reactivate", Method: http.MethodPost, Permissions: []string{rbac.PermUsersAdmin}, Summary: "Reactivate a user", Response: map[string]interface{}{"user": model.User{}}}
+	impersonateUser := router_pkg.Endpoint{Path: "/:id/impersonate", Method: http.MethodPost, Permissions: []string{rbac.PermUsersAdmin}, Summary: "Issue a token acting as a user", Request: handler.ImpersonatePayload{}, Response: map[string]interface{}{"token": "", "expires_in": 0, "allow_write": false}}
+	forcePasswordReset := router_pkg.Endpoint{Path: "/:id/password-reset", Method: http.MethodPost, Permissions: []string{rbac.PermUsersAdmin}, Summary: "Force a user to reset their password"}
 
-	getToken := router_pkg.Endpoint{Path: "/api/auth/token", Method: http.MethodPost}
+	// :id of these is always "me", see handler.MeOnly
+	meRoutes := userRoutes.Group("/:id", handler.MeOnly).Describe([]string{"account"}, openapi.SecurityBearer)
+	changePassword := router_pkg.Endpoint{Path: "/password", Method: http.MethodPut, Summary: "Change the password", Request: handler.ChangePasswordPayload{}, Response: tokens}
+	changeEmail := router_pkg.Endpoint{Path: "/email", Method: http.MethodPut, Summary: "Change the email", Request: handler.ChangeEmailPayload{}, Status: http.StatusAccepted}
+	createApiKey := router_pkg.Endpoint{Path: "/api-keys", Method: http.MethodPost, Summary: "Create an API key", Request: model.ApiKey{}, Response: map[string]interface{}{"api_key": "", "key": model.ApiKey{}}, Status: http.StatusCreated}
+	getAllApiKeys := router_pkg.Endpoint{Path: "/api-keys", Method: http.MethodGet, Summary: "returns API keys list", Response: map[string]interface{}{"api_keys": []model.ApiKey{}}}
+	deleteApiKey := router_pkg.Endpoint{Path: "/api-keys/:api_key_id", Method: http.MethodDelete, Summary: "Revoke an API key", Status: http.StatusNoContent}
+	getAllSessions := router_pkg.Endpoint{Path: "/sessions", Method: http.MethodGet, Summary: "returns sessions list", Response: map[string]interface{}{"sessions": []handler.SessionView{}}}
+	endSession := router_pkg.Endpoint{Path: "/sessions/:session_id", Method: http.MethodDelete, Summary: "End a session"}
+
+	clientRoutes := router.Group("/api/oauth/clients", auth).Preflight(corsPolicy).Describe([]string{"oauth client"}, openapi.SecurityBearer)
+	createClient := router_pkg.Endpoint{Method: http.MethodPost, Summary: "Create an OAuth client", Request: model.Client{}, Response: map[string]interface{}{"client": model.Client{}, "client_id": "", "client_secret": ""}, Status: http.StatusCreated}
+	getAllClients := router_pkg.Endpoint{Method: http.MethodGet, Summary: "returns OAuth clients list", Response: map[string]interface{}{"clients": []model.Client{}}}
+	deleteClient := router_pkg.Endpoint{Path: "/:id", Method: http.MethodDelete, Summary: "Delete an OAuth client", Status: http.StatusNoContent}
+
+	organizationRoutes := router.Group("/api/organizations", auth).Preflight(corsPolicy).Describe([]string{"organization"}, openapi.SecurityBearer)
+	createOrganization := router_pkg.Endpoint{Method: http.MethodPost, Summary: "Create an organization", Request: model.Organization{}, Response: map[string]interface{}{"organization": model.Organization{}}, Status: http.StatusCreated}
+	getAllOrganizations := router_pkg.Endpoint{Method: http.MethodGet, Summary: "returns the user's organizations list", Response: map[string]interface{}{"organizations": []controller.OrganizationView{}}}
+	switchOrganization := router_pkg.Endpoint{Path: "/:id/switch", Method: http.MethodPost, Summary: "Switch the active organization", Response: map[string]interface{}{"token": ""}}
+	getAllOrganizationMembers := router_pkg.Endpoint{Path: "/:id/members", Method: http.MethodGet, Summary: "returns organization members list", Response: map[string]interface{}{"members": []model.OrganizationMember{}}}
+	addOrganizationMember := router_pkg.Endpoint{Path: "/:id/members", Method: http.MethodPost, Summary: "Add an organization member", Request: controller.MemberPayload{}, Response: map[string]interface{}{"member": model.OrganizationMember{}}, Status: http.StatusCreated}
+	updateOrganizationMember := router_pkg.Endpoint{Path: "/:id/members/:email", Method: http.MethodPut, Summary: "Change the role of an organization member", Request: controller.MemberPayload{}}
+	removeOrganizationMember := router_pkg.Endpoint{Path: "/:id/members/:email", Method: http.MethodDelete, Summary: "Remove an organization member"}
+
+	customerRoutes := router.Group("/api/customers", auth).Preflight(corsPolicy).Describe([]string{"customer"}, openapi.SecurityBearer, openapi.SecurityApiKey)
+	createCustomer := router_pkg.Endpoint{Method: http.MethodPost, Middlewares: []middleware.Middleware{customerValidation}, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Create a customer", Request: model.Customer{}, Response: map[string]interface{}{"id": ""}, Status: http.StatusCreated}
+	getAllCustomers := router_pkg.Endpoint{Method: http.MethodGet, Permissions: []string{rbac.PermCustomersRead}, Summary: "returns customers list", Response: map[string]interface{}{"customers": []model.Customer{}, "__next": ""}}
+	getCustomerById := router_pkg.Endpoint{Path: "/:id", Method: http.MethodGet, Permissions: []string{rbac.PermCustomersRead}, Summary: "returns a customer", Response: map[string]interface{}{"customer": model.Customer{}}}
+	getNextCustomers := router_pkg.Endpoint{Path: "/:id/next", Method: http.MethodGet, Permissions: []string{rbac.PermCustomersRead}, Summary: "returns the customers after :id", Response: map[string]interface{}{"customers": []model.Customer{}, "__next": "", "__prev": ""}}
+	getPrevCustomers := router_pkg.Endpoint{Path: "/:id/prev", Method: http.MethodGet, Permissions: []string{rbac.PermCustomersRead}, Summary: "returns the customers before :id", Response: map[string]interface{}{"customers": []model.Customer{}, "__next": "", "__prev": ""}}
+	updateCustomer := router_pkg.Endpoint{Path: "/:id", Method: http.MethodPut, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Update a customer", Request: model.Customer{}, Response: map[string]interface{}{"customer": model.Customer{}}}
+	deleteCustomer := router_pkg.Endpoint{Path: "/:id", Method: http.MethodDelete, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Delete a customer", Status: http.StatusNoContent}
+	getAllCustomerShares := router_pkg.Endpoint{Path: "/:id/shares", Method: http.MethodGet, Permissions: []string{rbac.PermCustomersWrite}, Summary: "returns the users a customer is shared with", Response: map[string]interface{}{"shares": []model.CustomerShare{}}}
+	shareCustomer := router_pkg.Endpoint{Path: "/:id/shares/:email", Method: http.MethodPut, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Share a customer with a user", Request: controller.SharePayload{}, Response: map[string]interface{}{"share": model.CustomerShare{}}}
+	unshareCustomer := router_pkg.Endpoint{Path: "/:id/shares/:email", Method: http.MethodDelete, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Stop sharing a customer with a user", Status: http.StatusNoContent}
+	transferCustomer := router_pkg.Endpoint{Path: "/:id/transfer", Method: http.MethodPost, Permissions: []string{rbac.PermCustomersWrite}, Summary: "Transfer the ownership of a customer", Request: controller.TransferPayload{}, Response: map[string]interface{}{"transfer": model.CustomerTransfer{}}}
 
-	createCustomer := router_pkg.Endpoint{Path: "/api/customers", Method: http.MethodPost, Middlewares: []middleware.Middleware{auth, customerValidation}}
-	getAllCustomers := router_pkg.Endpoint{Path: "/api/customers", Method: http.MethodGet, Middlewares: []middleware.Middleware{auth}}
-	getCustomerById := router_pkg.Endpoint{Path: "/api/customers/:id", Method: http.MethodGet, Middlewares: []middleware.Middleware{auth}}
-	updateCustomer := router_pkg.Endpoint{Path: "/api/customers/:id", Method: http.MethodPut, Middlewares: []middleware.Middleware{auth}}
-	deleteCustomer := router_pkg.Endpoint{Path: "/api/customers/:id", Method: http.MethodDelete, Middlewares: []middleware.Middleware{auth}}
 	documentation := router_pkg.Endpoint{Path: "/restful-api", Method: http.MethodGet}
+	openapiDocument := router_pkg.Endpoint{Path: "/swagger", Method: http.MethodGet}
+	jwks := router_pkg.Endpoint{Path: "/.well-known/jwks.json", Method: http.MethodGet}
+	routes := router_pkg.Endpoint{Path: "/api/_routes", Method: http.MethodGet, Middlewares: []middleware.Middleware{auth}, Permissions: []string{rbac.PermUsersAdmin}, Tags: []string{"user"}, Security: []string{openapi.SecurityBearer}, Summary: "returns handled routes list", Response: map[string]interface{}{"routes": []router_pkg.Route{}}}
 
 	router.Handle(helloWorld, func(writer http.ResponseWriter, request *http.Request, parameters httprouter.Params) {
 		writer.Header().Set("Content-Type", "text/html")
 		writer.WriteHeader(http.StatusOK)
 		writer.Write(index_html)
 	})
-	router.Handle(signUp, handler.CreateUser)
-	router.Handle(signIn, handler.ReadUser)
-	router.Handle(getToken, auth_pkg.Token)
-	router.Handle(createCustomer, controller_customer.Create)
-	router.Handle(getAllCustomers, controller_customer.ReadAll)
-	router.Handle(router_pkg.Endpoint{Path: "/api/customers/:id/next", Method: http.MethodGet, Middlewares: []middleware.Middleware{auth}}, controller_customer.ReadNext)
-	router.Handle(router_pkg.Endpoint{Path: "/api/customers/:id/prev", Method: http.MethodGet, Middlewares: []middleware.Middleware{auth}}, controller_customer.ReadPrev)
-	router.Handle(updateCustomer, controller_customer.UpdateById)
-	router.Handle(deleteCustomer, controller_customer.Delete)
-	router.Handle(getCustomerById, controller_customer.ReadById)
+	authRoutes.Handle(signUp, handler.CreateUser)
+	authRoutes.Handle(signIn, handler.ReadUser)
+	authRoutes.Handle(refreshToken, handler.RefreshToken)
+	authRoutes.Handle(logout, auth_pkg.Logout)
+	authRoutes.Handle(logoutAll, auth_pkg.LogoutAll)
+	authRoutes.Handle(forgotPassword, handler.ForgotPassword)
+	authRoutes.Handle(resetPassword, handler.ResetPassword)
+	authRoutes.Handle(verifyEmailLink, handler.VerifyEmail)
+	authRoutes.Handle(verifyEmail, handler.VerifyEmail)
+	authRoutes.Handle(resendVerification, handler.ResendVerification)
+	authRoutes.Handle(unlockUser, handler.UnlockUser)
+	authRoutes.Handle(twoFactor, handler.VerifyTwoFactor)
+	authRoutes.Handle(enrollTwoFactor, handler.EnrollTwoFactor)
+	authRoutes.Handle(confirmTwoFactor, handler.ConfirmTwoFactor)
+	authRoutes.Handle(disableTwoFactor, handler.DisableTwoFactor)
+	authRoutes.Handle(getToken, controller_client.Token)
+	meRoutes.Handle(changePassword, handler.ChangePassword)
+	meRoutes.Handle(changeEmail, handler.ChangeEmail)
+	meRoutes.Handle(getAllSessions, handler.ReadSessions)
+	meRoutes.Handle(endSession, handler.EndSession)
+	meRoutes.Handle(createApiKey, controller_api_key.Create)
+	meRoutes.Handle(getAllApiKeys, controller_api_key.ReadAll)
+	meRoutes.Handle(deleteApiKey, controller_api_key.Delete)
+	userRoutes.Handle(getAllUsers, handler.ReadUsers)
+	userRoutes.Handle(getUserById, handler.ReadUserById)
+	userRoutes.Handle(deleteUser, handler.DeleteUser)
+	userRoutes.Handle(deactivateUser, handler.DeactivateUser)
+	userRoutes.Handle(reactivateUser, handler.ReactivateUser)
+	userRoutes.Handle(forcePasswordReset, handler.ForcePasswordReset)
+	userRoutes.Handle(impersonateUser, handler.ImpersonateUser)
+	router.Handle(jwks, auth_pkg.Jwks)
+	router.Handle(routes, router.ServeRoutes)
+	clientRoutes.Handle(createClient, controller_client.Create)
+	clientRoutes.Handle(getAllClients, controller_client.ReadAll)
+	clientRoutes.Handle(deleteClient, controller_client.Delete)
+	organizationRoutes.Handle(createOrganization, controller_organization.Create)
+	organizationRoutes.Handle(getAllOrganizations, controller_organization.ReadAll)
+	organizationRoutes.Handle(switchOrganization, controller_organization.Switch)
+	organizationRoutes.Handle(getAllOrganizationMembers, controller_organization.ReadMembers)
+	organizationRoutes.Handle(addOrganizationMember, controller_organization.AddMember)
+	organizationRoutes.Handle(updateOrganizationMember, controller_organization.UpdateMember)
+	organizationRoutes.Handle(removeOrganizationMember, controller_organization.RemoveMember)
+
+	if oidc_enabled {
+		authRoutes.Handle(oidcLogin, handler.OidcLogin)
+		authRoutes.Handle(oidcCallback, handler.OidcCallback)
+	}
+
+	if auth_pkg.DevModeEnabled() {
+		log.Println("AUTH_DEV_MODE is enabled: /api/auth/dev-token issues tokens without a password, never enable it in production")
+
+		authRoutes.Handle(devToken, auth_pkg.DevToken)
+	}
+
+	customerRoutes.Handle(createCustomer, controller_customer.Create)
+	customerRoutes.Handle(getAllCustomers, controller_customer.ReadAll)
+	customerRoutes.Handle(getNextCustomers, controller_customer.ReadNext)
+	customerRoutes.Handle(getPrevCustomers, controller_customer.ReadPrev)
+	customerRoutes.Handle(updateCustomer, controller_customer.UpdateById)
+	customerRoutes.Handle(deleteCustomer, controller_customer.Delete)
+	customerRoutes.Handle(getCustomerById, controller_customer.ReadById)
+	customerRoutes.Handle(getAllCustomerShares, controller_customer.ReadShares)
+	customerRoutes.Handle(shareCustomer, controller_customer.Share)
+	customerRoutes.Handle(unshareCustomer, controller_customer.Unshare)
+	customerRoutes.Handle(transferCustomer, controller_customer.Transfer)
 	router.Handle(router_pkg.Endpoint{Path: "/swagger-css", Method: http.MethodGet}, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		w.Header().Set("Content-Type", "text/css")
 		w.WriteHeader(http.StatusOK)
@@ -108,11 +300,20 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		w.Write(swagger_ui_html)
 	})
-	router.Handle(router_pkg.Endpoint{Path: "/swagger", Method: http.MethodGet}, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		w.Header().Set("Content-Type", "text/yaml")
-		w.WriteHeader(http.StatusOK)
-		w.Write(swagger_yaml)
-	})
+	router.Handle(openapiDocument, openapi.Handler(openapi.Info{
+		Title:       "RESTful API",
+		Version:     "1.0.0",
+		Description: "This is the minimum RESTful API",
+		Contact: &openapi.Contact{
+			Name:  "Muhamamd Miftah Rizki",
+			Email: "muhamamdmiftahrizki@gmail.com",
+		},
+	}, router))
+
+	err = router.Err()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	server := http.Server{
 		Addr:    os.Getenv("BASE_URL") + ":" + os.Getenv("PORT"),
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+const req_header_api_key string = "X-API-Key"
+const api_key_prefix string = "ak_"
+
+var ErrInvalidApiKey = errors.New("auth: invalid api key")
+
+// IApiKeyStore resolves a hashed API key into the claims of its owner,
+// limited to the key's scope. It returns ErrInvalidApiKey for unknown,
+// revoked or expired keys.
+type IApiKeyStore interface {
+	Authenticate(ctx context.Context, hash string) (*JwtClaims, error)
+}
+
+// api_key_store stays nil until SetApiKeyStore is called, API keys are
+// refused until then.
+var api_key_store IApiKeyStore
+
+func SetApiKeyStore(store IApiKeyStore) {
+	api_key_store = store
+}
+
+// GenerateApiKey returns a new API key together with the hash that should be
+// persisted in its place and a short prefix to tell keys apart in listings.
+func GenerateApiKey() (string, string, string, error) {
+	token_str, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key_str := api_key_prefix + token_str
+
+	return key_str, HashOpaqueToken(key_str), key_str[:len(api_key_prefix)+6], nil
+}
+
+func authenticateApiKey(ctx context.Context, key_str string) (*JwtClaims, error) {
+	if api_key_store == nil {
+		return nil, ErrInvalidApiKey
+	}
+
+	return api_key_store.Authenticate(ctx, HashOpaqueToken(key_str))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -12,44 +11,76 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/mmiftahrzki/go-rest-api/middleware"
 	"github.com/mmiftahrzki/go-rest-api/response"
 )
 
 type JwtClaims struct {
-	Email string `json:"email"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles,omitempty"`
+	ClientId string   `json:"client_id,omitempty"`
+	ApiKeyId string   `json:"api_key_id,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	// OrganizationId is the tenant the token works in. Customers of other
+	// organizations are out of its reach.
+	OrganizationId string `json:"org_id,omitempty"`
+	// SessionId ties a user's token to the sign-in it came from, ending the
+	// session rejects the token.
+	SessionId string `json:"sid,omitempty"`
+	// Actor is set on impersonation tokens, see GenerateImpersonationToken.
+	Actor *Actor `json:"act,omitempty"`
+	// Purpose is set on tokens that are only good for one step of a flow,
+	// such as the 2fa challenge. authHandler refuses them.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-type signinpayload struct {
+type SignInPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type DevTokenPayload struct {
+	Email          string `json:"email"`
+	OrganizationId string `json:"organization_id"`
+}
+
 type jwtContextKey int
 
 const key jwtContextKey = iota
 const req_header_auth_key string = "Authorization"
+const access_token_lifetime time.Duration = 30 * time.Minute
+const challenge_token_lifetime time.Duration = 5 * time.Minute
+
+const PurposeTwoFactor string = "2fa"
 
 var errEmptyAuth = errors.New("authorization header not found")
 var errInvalidAuth = errors.New("invalid authorization header")
 
-func extractAuthTokenStr(auth_value string) (string, error) {
-	var token_str string
+const auth_scheme_bearer string = "Bearer"
+const auth_scheme_api_key string = "ApiKey"
+
+// extractCredential returns the scheme and the credential of the request. An
+// API key can be sent in X-API-Key or as "Authorization: ApiKey <key>".
+func extractCredential(request *http.Request) (string, string, error) {
+	api_key := request.Header.Get(req_header_api_key)
+	if api_key != "" {
+		return auth_scheme_api_key, api_key, nil
+	}
 
+	auth_value := request.Header.Get(req_header_auth_key)
 	if len(auth_value) == 0 {
-		return token_str, errEmptyAuth
+		return "", "", errEmptyAuth
 	}
 
 	auth_value_fields := strings.Fields(auth_value)
-	if len(auth_value_fields) != 2 || auth_value_fields[0] != "Bearer" {
-		return token_str, errInvalidAuth
+	if len(auth_value_fields) != 2 || (auth_value_fields[0] != auth_scheme_bearer && auth_value_fields[0] != auth_scheme_api_key) {
+		return "", "", errInvalidAuth
 	}
 
-	token_str = auth_value_fields[1]
-
-	return token_str, nil
+	return auth_value_fields[0], auth_value_fields[1], nil
 }
 
 func ExtractAuthClaims(ctx context.Context) (*JwtClaims, error) {
@@ -62,6 +93,12 @@ func ExtractAuthClaims(ctx context.Context) (*JwtClaims, error) {
 	return claims, nil
 }
 
+// WithClaims returns a copy of ctx carrying claims, the way authHandler hands
+// them to the next handler.
+func WithClaims(ctx context.Context, claims *JwtClaims) context.Context {
+	return context.WithValue(ctx, key, claims)
+}
+
 func New() middleware.Middleware {
 	return authHandler
 }
@@ -71,8 +108,7 @@ func authHandler(next httprouter.Handle) httprouter.Handle {
 		writer.Header().Set("Content-Type", "application/json")
 		response := response.New()
 
-		auth_value := request.Header.Get(req_header_auth_key)
-		token_str, err := extractAuthTokenStr(auth_value)
+		scheme, credential, err := extractCredential(request)
 		if err != nil {
 			response.Message = err.Error()
 
@@ -82,57 +118,163 @@ func authHandler(next httprouter.Handle) httprouter.Handle {
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(token_str, &JwtClaims{}, func(t *jwt.Token) (interface{}, error) {
-			method, ok := t.Method.(*jwt.SigningMethodHMAC)
-			if !ok || method != jwt.SigningMethodHS256 {
-				return nil, fmt.Errorf("invalid signing method")
-			}
+		var claims *JwtClaims
+		var ok bool
+		if scheme == auth_scheme_api_key {
+			claims, ok = apiKeyClaims(writer, request, credential)
+		} else {
+			claims, ok = jwtClaims(writer, request, credential)
+		}
 
-			return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-		})
+		if !ok {
+			return
+		}
 
-		if err != nil {
-			response.Message = err.Error()
+		request = request.WithContext(WithClaims(request.Context(), claims))
 
-			writer.WriteHeader(http.StatusBadRequest)
-			writer.Write([]byte(response.ToJson()))
+		if claims.IsImpersonated() {
+			serveImpersonated(writer, request, params, claims, next)
 
 			return
 		}
 
-		if !token.Valid {
-			response.Message = "invalid jwt"
+		next(writer, request, params)
+	}
+}
 
-			writer.WriteHeader(http.StatusUnauthorized)
-			writer.Write([]byte(response.ToJson()))
+// jwtClaims validates a Bearer token. It writes the error response itself
+// and returns false when the request must not go on.
+func jwtClaims(writer http.ResponseWriter, request *http.Request, token_str string) (*JwtClaims, bool) {
+	response := response.New()
 
-			return
-		}
+	token, err := jwt.ParseWithClaims(token_str, &JwtClaims{}, verificationKey)
 
-		claims, ok := token.Claims.(*JwtClaims)
-		if !ok {
-			response.Message = "invalid jwt claims"
+	if err != nil {
+		response.Message = err.Error()
 
-			writer.WriteHeader(http.StatusBadRequest)
-			writer.Write([]byte(response.ToJson()))
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(response.ToJson()))
 
-			return
+		return nil, false
+	}
+
+	if !token.Valid {
+		response.Message = "invalid jwt"
+
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.Write([]byte(response.ToJson()))
+
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(*JwtClaims)
+	if !ok {
+		response.Message = "invalid jwt claims"
+
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(response.ToJson()))
+
+		return nil, false
+	}
+
+	if claims.Purpose != "" {
+		response.Message = "invalid jwt"
+
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.Write(response.ToJson())
+
+		return nil, false
+	}
+
+	revoked, err := revocation_store.IsRevoked(request.Context(), claims)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return nil, false
+	}
+
+	if revoked {
+		response.Message = "jwt has been revoked"
+
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.Write(response.ToJson())
+
+		return nil, false
+	}
+
+	active, err := checkSession(request.Context(), claims)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return nil, false
+	}
+
+	if !active {
+		response.Message = "session has ended"
+
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.Write(response.ToJson())
+
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// apiKeyClaims is jwtClaims for API keys.
+func apiKeyClaims(writer http.ResponseWriter, request *http.Request, key_str string) (*JwtClaims, bool) {
+	response := response.New()
+
+	claims, err := authenticateApiKey(request.Context(), key_str)
+	if err != nil {
+		if errors.Is(err, ErrInvalidApiKey) {
+			response.Message = "invalid api key"
+
+			writer.WriteHeader(http.StatusUnauthorized)
+			writer.Write(response.ToJson())
+
+			return nil, false
 		}
 
-		request = request.WithContext(context.WithValue(request.Context(), key, claims))
+		log.Println(err)
 
-		next(writer, request, params)
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return nil, false
 	}
+
+	return claims, true
 }
 
-func NewSignInPayload() *signinpayload {
-	return &signinpayload{}
+func NewSignInPayload() *SignInPayload {
+	return &SignInPayload{}
 }
 
-func Token(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	var payload signinpayload
+// DevToken signs a token for whatever email is in the body without checking
+// a password, working in the organization_id from the body if any. It is
+// only meant for local testing and refuses to work unless AUTH_DEV_MODE is
+// set to "true".
+func DevToken(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	var payload DevTokenPayload
 	response := response.New()
 
+	if !DevModeEnabled() {
+		response.Message = http.StatusText(http.StatusNotFound)
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
 	json_decoder := json.NewDecoder(request.Body)
 	err := json_decoder.Decode(&payload)
 	if err != nil {
@@ -147,19 +289,15 @@ func Token(writer http.ResponseWriter, request *http.Request, params httprouter.
 		return
 	}
 
-	// registerd_claims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute))}
-	// claims := JwtClaims{
-	// 	Email:            payload.Email,
-	// 	RegisteredClaims: registerd_claims,
-	// }
+	claims := newClaims(payload.Email, []string{"staff"})
+	claims.OrganizationId = payload.OrganizationId
 
-	// token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// ss, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
-	token, err := GenerateToken(payload)
+	token, err := signToken(claims)
 	if err != nil {
 		log.Println(err)
 
 		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusInternalServerError)
 		writer.Write(response.ToJson())
 
 		return
@@ -173,18 +311,99 @@ func Token(writer http.ResponseWriter, request *http.Request, params httprouter.
 	writer.Write(response.ToJson())
 }
 
-func GenerateToken(payload signinpayload) (string, error) {
-	registerd_claims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute))}
-	claims := JwtClaims{
-		Email:            payload.Email,
+func DevModeEnabled() bool {
+	return os.Getenv("AUTH_DEV_MODE") == "true"
+}
+
+func newClaims(email string, roles []string) JwtClaims {
+	now := time.Now()
+	registerd_claims := jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(access_token_lifetime)),
+	}
+
+	return JwtClaims{
+		Email:            email,
+		Roles:            roles,
 		RegisteredClaims: registerd_claims,
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed_string, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+func GenerateToken(email string, roles []string) (string, error) {
+	claims := newClaims(email, roles)
+
+	signed_string, err := signToken(claims)
 	if err != nil {
 		return signed_string, err
 	}
 
 	return signed_string, nil
 }
+
+// GenerateSessionToken signs a user's access token belonging to session_id,
+// working in organization_id.
+func GenerateSessionToken(email string, roles []string, organization_id string, session_id uuid.UUID) (string, error) {
+	claims := newClaims(email, roles)
+	claims.OrganizationId = organization_id
+	claims.SessionId = session_id.String()
+
+	return signToken(claims)
+}
+
+// GenerateClientToken signs a client credentials token. The token acts on
+// behalf of the client's owner in organization_id, limited to scope.
+func GenerateClientToken(client_id, owner_email string, owner_roles []string, organization_id, scope string) (string, *JwtClaims, error) {
+	claims := newClaims(owner_email, owner_roles)
+	claims.OrganizationId = organization_id
+	claims.Subject = client_id
+	claims.ClientId = client_id
+	claims.Scope = scope
+
+	signed_string, err := signToken(claims)
+	if err != nil {
+		return signed_string, nil, err
+	}
+
+	return signed_string, &claims, nil
+}
+
+// GenerateChallengeToken signs a short-lived token that only proves the
+// password step of sign-in succeeded. It can only be exchanged at the
+// endpoint handling purpose.
+func GenerateChallengeToken(email, purpose string) (string, error) {
+	claims := newClaims(email, nil)
+	claims.Purpose = purpose
+	claims.ExpiresAt = jwt.NewNumericDate(claims.IssuedAt.Time.Add(challenge_token_lifetime))
+
+	return signToken(claims)
+}
+
+// ParseChallengeToken validates a token from GenerateChallengeToken. Tokens
+// minted for another purpose, expired or already revoked tokens are refused.
+func ParseChallengeToken(ctx context.Context, token_str, purpose string) (*JwtClaims, error) {
+	token, err := jwt.ParseWithClaims(token_str, &JwtClaims{}, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*JwtClaims)
+	if !ok || !token.Valid || claims.Purpose != purpose {
+		return nil, errors.New("auth: invalid challenge token")
+	}
+
+	revoked, err := revocation_store.IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("auth: challenge token revoked")
+	}
+
+	return claims, nil
+}
+
+func AccessTokenLifetime() time.Duration {
+	return access_token_lifetime
+}
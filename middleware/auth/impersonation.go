@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/audit"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const res_header_impersonated_by string = "X-Impersonated-By"
+const impersonation_token_lifetime = 15 * time.Minute
+
+// audit_record_timeout bounds writing the audit entry of an impersonated
+// request, which must not depend on the client still waiting.
+const audit_record_timeout time.Duration = 5 * time.Second
+
+// Actor is who really holds an impersonation token. The token's Email is the
+// impersonated user.
+type Actor struct {
+	Email string `json:"email"`
+	// AllowWrite lets the token change data, impersonation tokens are read
+	// only otherwise.
+	AllowWrite bool `json:"write,omitempty"`
+}
+
+// IsImpersonated reports whether the claims belong to someone acting as
+// another user.
+func (claims *JwtClaims) IsImpersonated() bool {
+	return claims.Actor != nil
+}
+
+// GenerateImpersonationToken signs a token that lets actor act as email with
+// roles in organization_id. It belongs to the actor's session, so it ends
+// with it, and cannot be refreshed.
+func GenerateImpersonationToken(actor *JwtClaims, email string, roles []string, organization_id string, allow_write bool) (string, *JwtClaims, error) {
+	claims := newClaims(email, roles)
+	claims.OrganizationId = organization_id
+	claims.ExpiresAt = jwt.NewNumericDate(claims.IssuedAt.Time.Add(impersonation_token_lifetime))
+	claims.SessionId = actor.SessionId
+	claims.Actor = &Actor{
+		Email:      actor.Email,
+		AllowWrite: allow_write,
+	}
+
+	signed_string, err := signToken(claims)
+	if err != nil {
+		return signed_string, nil, err
+	}
+
+	return signed_string, &claims, nil
+}
+
+func ImpersonationTokenLifetime() time.Duration {
+	return impersonation_token_lifetime
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status_code int
+}
+
+func (recorder *statusRecorder) WriteHeader(status_code int) {
+	recorder.status_code = status_code
+	recorder.ResponseWriter.WriteHeader(status_code)
+}
+
+// serveImpersonated runs next for a request made with an impersonation
+// token. The response names the actor in X-Impersonated-By, the request is
+// written to the audit log, and unless the token allows it only reading
+// methods get through.
+func serveImpersonated(writer http.ResponseWriter, request *http.Request, params httprouter.Params, claims *JwtClaims, next httprouter.Handle) {
+	writer.Header().Set(res_header_impersonated_by, claims.Actor.Email)
+	recorder := &statusRecorder{ResponseWriter: writer, status_code: http.StatusOK}
+
+	defer func() {
+		remote_addr, _, err := net.SplitHostPort(request.RemoteAddr)
+		if err != nil {
+			remote_addr = request.RemoteAddr
+		}
+
+		// the request context is cancelled once the client goes away, the
+		// entry has to be written anyway
+		ctx, cancel := context.WithTimeout(context.Background(), audit_record_timeout)
+		defer cancel()
+
+		err = audit.Record(ctx, audit.Entry{
+			Action:     audit.ActionImpersonatedRequest,
+			Actor:      claims.Actor.Email,
+			Subject:    claims.Email,
+			Method:     request.Method,
+			Path:       request.URL.RequestURI(),
+			StatusCode: recorder.status_code,
+			RemoteAddr: remote_addr,
+			Detail:     claims.ID,
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !claims.Actor.AllowWrite {
+			response := response.New()
+			response.Message = "token impersonasi hanya dapat membaca data"
+
+			recorder.WriteHeader(http.StatusForbidden)
+			recorder.Write(response.ToJson())
+
+			return
+		}
+	}
+
+	next(recorder, request, params)
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+var errUnsupportedKey = errors.New("unsupported key type")
+
+type Key struct {
+	Id          string
+	Method      jwt.SigningMethod
+	private_key interface{}
+	public_key  interface{}
+}
+
+type keySet struct {
+	mutex        sync.RWMutex
+	signing      *Key
+	verification map[string]*Key
+	// accept_secret lets kid-less HS256 tokens signed with JWT_SECRET_KEY
+	// through
+	accept_secret bool
+}
+
+var keys = &keySet{verification: map[string]*Key{}, accept_secret: true}
+
+// LoadKeys reads the asymmetric signing keys from the environment.
+//
+//	JWT_SIGNING_KEY         [kid=]path to the PEM private key new tokens are signed with
+//	JWT_VERIFICATION_KEYS   comma separated [kid=]paths of PEM keys that are still accepted
+//	JWT_ACCEPT_HS256        "true" to keep accepting HS256 tokens next to JWT_SIGNING_KEY
+//
+// When a kid is omitted it is derived from the public key. Without
+// JWT_SIGNING_KEY tokens keep being signed with HS256 and JWT_SECRET_KEY. With
+// it, HS256 tokens are refused unless JWT_ACCEPT_HS256 is set, e.g. while the
+// tokens issued before the switch expire.
+func LoadKeys() error {
+	signing_key_env := strings.TrimSpace(os.Getenv("JWT_SIGNING_KEY"))
+	verification_keys_env := strings.TrimSpace(os.Getenv("JWT_VERIFICATION_KEYS"))
+
+	var signing *Key
+	verification := map[string]*Key{}
+
+	if signing_key_env != "" {
+		key, err := loadKeyFile(signing_key_env)
+		if err != nil {
+			return err
+		}
+
+		if key.private_key == nil {
+			return fmt.Errorf("auth: signing key %s is not a private key", key.Id)
+		}
+
+		signing = key
+		verification[key.Id] = key
+	}
+
+	for _, entry := range strings.Split(verification_keys_env, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, err := loadKeyFile(entry)
+		if err != nil {
+			return err
+		}
+
+		_, exists := verification[key.Id]
+		if exists {
+			if signing != nil && key.Id == signing.Id {
+				continue
+			}
+
+			return fmt.Errorf("auth: duplicate key id %s", key.Id)
+		}
+
+		verification[key.Id] = key
+	}
+
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+
+	keys.signing = signing
+	keys.verification = verification
+	keys.accept_secret = signing == nil || os.Getenv("JWT_ACCEPT_HS256") == "true"
+
+	return nil
+}
+
+func loadKeyFile(entry string) (*Key, error) {
+	kid := ""
+	path := entry
+
+	fields := strings.SplitN(entry, "=", 2)
+	if len(fields) == 2 {
+		kid = strings.TrimSpace(fields[0])
+		path = strings.TrimSpace(fields[1])
+	}
+
+	pem_bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: read key %s: %w", path, err)
+	}
+
+	key, err := parseKey(pem_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("auth: parse key %s: %w", path, err)
+	}
+
+	key.Id = kid
+	if key.Id == "" {
+		key.Id, err = thumbprint(key.public_key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func parseKey(pem_bytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pem_bytes)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch value := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Method: jwt.SigningMethodRS256, private_key: value, public_key: &value.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{Method: jwt.SigningMethodRS256, public_key: value}, nil
+	case ed25519.PrivateKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, private_key: value, public_key: value.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, public_key: value}, nil
+	}
+
+	return nil, errUnsupportedKey
+}
+
+func thumbprint(public_key interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public_key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// signToken signs claims with the current signing key, falling back to HS256
+// when no asymmetric key is configured.
+func signToken(claims jwt.Claims) (string, error) {
+	keys.mutex.RLock()
+	signing := keys.signing
+	keys.mutex.RUnlock()
+
+	if signing == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+		return token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	}
+
+	token := jwt.NewWithClaims(signing.Method, claims)
+	token.Header["kid"] = signing.Id
+
+	return token.SignedString(signing.private_key)
+}
+
+// verificationKey is the jwt.Keyfunc used by authHandler. Tokens carrying a
+// kid must match one of the loaded keys, tokens without one are HS256 tokens
+// signed with JWT_SECRET_KEY, accepted only as LoadKeys describes.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		method, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok || method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("invalid signing method")
+		}
+
+		keys.mutex.RLock()
+		accept_secret := keys.accept_secret
+		keys.mutex.RUnlock()
+
+		secret := os.Getenv("JWT_SECRET_KEY")
+		if !accept_secret || secret == "" {
+			return nil, errUnknownKey
+		}
+
+		return []byte(secret), nil
+	}
+
+	keys.mutex.RLock()
+	key, ok := keys.verification[kid]
+	keys.mutex.RUnlock()
+
+	if !ok {
+		return nil, errUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("invalid signing method")
+	}
+
+	return key.public_key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (key *Key) jwk() (jwk, error) {
+	result := jwk{Kid: key.Id, Use: "sig", Alg: key.Method.Alg()}
+
+	switch public_key := key.public_key.(type) {
+	case *rsa.PublicKey:
+		result.Kty = "RSA"
+		result.N = base64.RawURLEncoding.EncodeToString(public_key.N.Bytes())
+		result.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public_key.E)).Bytes())
+	case ed25519.PublicKey:
+		result.Kty = "OKP"
+		result.Crv = "Ed25519"
+		result.X = base64.RawURLEncoding.EncodeToString(public_key)
+	default:
+		return result, errUnsupportedKey
+	}
+
+	return result, nil
+}
+
+// Jwks serves every key that tokens are currently verified with, so other
+// services can validate our tokens without holding a secret.
+func Jwks(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	keys.mutex.RLock()
+	key_ids := make([]string, 0, len(keys.verification))
+	for kid := range keys.verification {
+		key_ids = append(key_ids, kid)
+	}
+	sort.Strings(key_ids)
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+
+	for _, kid := range key_ids {
+		key, err := keys.verification[kid].jwk()
+		if err != nil {
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, key)
+	}
+	keys.mutex.RUnlock()
+
+	json_encoded, _ := json.Marshal(jwks)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(json_encoded)
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const refresh_token_lifetime time.Duration = 30 * 24 * time.Hour
+
+var ErrInvalidRefreshToken = errors.New("auth: invalid refresh token")
+var ErrRefreshTokenReused = errors.New("auth: refresh token reused")
+
+type RefreshToken struct {
+	Hash      string
+	FamilyId  uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	RevokedAt sql.NullTime
+	CreatedAt time.Time
+}
+
+type IRefreshTokenStore interface {
+	Insert(ctx context.Context, token RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (RefreshToken, error)
+	// MarkUsed reports false when the token had already been used, so a
+	// concurrent rotation of the same token can only succeed once.
+	MarkUsed(ctx context.Context, hash string, used_at time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, family_id uuid.UUID, revoked_at time.Time) error
+	RevokeAllForEmail(ctx context.Context, email string, revoked_at time.Time) error
+}
+
+var refresh_token_store IRefreshTokenStore = NewMemoryRefreshTokenStore()
+
+func SetRefreshTokenStore(store IRefreshTokenStore) {
+	refresh_token_store = store
+}
+
+// GenerateOpaqueToken returns a random url-safe token together with the
+// sha256 hash that should be persisted in its place.
+func GenerateOpaqueToken() (string, string, error) {
+	buffer := make([]byte, 32)
+
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", "", err
+	}
+
+	token_str := base64.RawURLEncoding.EncodeToString(buffer)
+
+	return token_str, HashOpaqueToken(token_str), nil
+}
+
+func HashOpaqueToken(token_str string) string {
+	sum := sha256.Sum256([]byte(token_str))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRefreshToken starts the refresh token family of a session of email.
+func GenerateRefreshToken(ctx context.Context, email string, session_id uuid.UUID) (string, error) {
+	return generateRefreshToken(ctx, email, session_id)
+}
+
+func generateRefreshToken(ctx context.Context, email string, family_id uuid.UUID) (string, error) {
+	token_str, hash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := RefreshToken{
+		Hash:      hash,
+		FamilyId:  family_id,
+		Email:     email,
+		ExpiresAt: now.Add(refresh_token_lifetime),
+		CreatedAt: now,
+	}
+
+	err = refresh_token_store.Insert(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	return token_str, nil
+}
+
+// RotateRefreshToken consumes token_str and returns its record together with
+// a new refresh token from the same family, which is the session the tokens
+// belong to. Presenting a token that was already rotated revokes the whole
+// family and ends the session.
+func RotateRefreshToken(ctx context.Context, token_str string) (RefreshToken, string, error) {
+	hash := HashOpaqueToken(token_str)
+	now := time.Now()
+
+	token, err := refresh_token_store.FindByHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return token, "", ErrInvalidRefreshToken
+		}
+
+		return token, "", err
+	}
+
+	if token.RevokedAt.Valid || now.After(token.ExpiresAt) {
+		return token, "", ErrInvalidRefreshToken
+	}
+
+	ok := false
+	if !token.UsedAt.Valid {
+		ok, err = refresh_token_store.MarkUsed(ctx, hash, now)
+		if err != nil {
+			return token, "", err
+		}
+	}
+
+	if !ok {
+		err = refresh_token_store.RevokeFamily(ctx, token.FamilyId, now)
+		if err != nil {
+			return token, "", err
+		}
+
+		err = session_store.End(ctx, token.FamilyId, now)
+		if err != nil {
+			return token, "", err
+		}
+
+		return token, "", ErrRefreshTokenReused
+	}
+
+	new_token_str, err := generateRefreshToken(ctx, token.Email, token.FamilyId)
+	if err != nil {
+		return token, "", err
+	}
+
+	err = session_store.Touch(ctx, token.FamilyId, now)
+	if err != nil {
+		return token, "", err
+	}
+
+	return token, new_token_str, nil
+}
+
+// RevokeRefreshToken revokes the family token_str belongs to and ends its
+// session. Unknown tokens are ignored.
+func RevokeRefreshToken(ctx context.Context, token_str string) error {
+	token, err := refresh_token_store.FindByHash(ctx, HashOpaqueToken(token_str))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	now := time.Now()
+
+	err = session_store.End(ctx, token.FamilyId, now)
+	if err != nil {
+		return err
+	}
+
+	return refresh_token_store.RevokeFamily(ctx, token.FamilyId, now)
+}
+
+type memoryRefreshTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func NewMemoryRefreshTokenStore() IRefreshTokenStore {
+	return &memoryRefreshTokenStore{
+		tokens: map[string]RefreshToken{},
+	}
+}
+
+func (store *memoryRefreshTokenStore) Insert(ctx context.Context, token RefreshToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.tokens[token.Hash] = token
+
+	return nil
+}
+
+func (store *memoryRefreshTokenStore) FindByHash(ctx context.Context, hash string) (RefreshToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, ok := store.tokens[hash]
+	if !ok {
+		return token, sql.ErrNoRows
+	}
+
+	return token, nil
+}
+
+func (store *memoryRefreshTokenStore) MarkUsed(ctx context.Context, hash string, used_at time.Time) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, ok := store.tokens[hash]
+	if !ok || token.UsedAt.Valid {
+		return false, nil
+	}
+
+	token.UsedAt = sql.NullTime{Time: used_at, Valid: true}
+	store.tokens[hash] = token
+
+	return true, nil
+}
+
+func (store *memoryRefreshTokenStore) RevokeFamily(ctx context.Context, family_id uuid.UUID, revoked_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, token := range store.tokens {
+		if token.FamilyId != family_id || token.RevokedAt.Valid {
+			continue
+		}
+
+		token.RevokedAt = sql.NullTime{Time: revoked_at, Valid: true}
+		store.tokens[hash] = token
+	}
+
+	return nil
+}
+
+func (store *memoryRefreshTokenStore) RevokeAllForEmail(ctx context.Context, email string, revoked_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hash, token := range store.tokens {
+		if token.Email != email || token.RevokedAt.Valid {
+			continue
+		}
+
+		token.RevokedAt = sql.NullTime{Time: revoked_at, Valid: true}
+		store.tokens[hash] = token
+	}
+
+	return nil
+}
+
+type mysqlRefreshTokenStore struct {
+	database_connection *sql.DB
+	table               string
+}
+
+func NewMySQLRefreshTokenStore(db *sql.DB, table_name string) IRefreshTokenStore {
+	return &mysqlRefreshTokenStore{
+		database_connection: db,
+		table:               table_name,
+	}
+}
+
+func (store *mysqlRefreshTokenStore) Insert(ctx context.Context, token RefreshToken) error {
+	sql_query := fmt.Sprintf("INSERT INTO %s(token_hash, family_id, email, expires_at, created_at) VALUES (?, ?, ?, ?, ?)", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, token.Hash, token.FamilyId.String(), token.Email, token.ExpiresAt, token.CreatedAt)
+
+	return err
+}
+
+func (store *mysqlRefreshTokenStore) FindByHash(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	var family_id string
+
+	sql_query := fmt.Sprintf("SELECT token_hash, family_id, email, expires_at, used_at, revoked_at, created_at FROM %s WHERE token_hash=?", store.table)
+	row := store.database_connection.QueryRowContext(ctx, sql_query, hash)
+
+	err := row.Scan(&token.Hash, &family_id, &token.Email, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt)
+	if err != nil {
+		return token, err
+	}
+
+	token.FamilyId, err = uuid.Parse(family_id)
+	if err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+func (store *mysqlRefreshTokenStore) MarkUsed(ctx context.Context, hash string, used_at time.Time) (bool, error) {
+	sql_query := fmt.Sprintf("UPDATE %s SET used_at=? WHERE token_hash=? AND used_at IS NULL", store.table)
+	result, err := store.database_connection.ExecContext(ctx, sql_query, used_at, hash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (store *mysqlRefreshTokenStore) RevokeFamily(ctx context.Context, family_id uuid.UUID, revoked_at time.Time) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET revoked_at=? WHERE family_id=? AND revoked_at IS NULL", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, revoked_at, family_id.String())
+
+	return err
+}
+
+func (store *mysqlRefreshTokenStore) RevokeAllForEmail(ctx context.Context, email string, revoked_at time.Time) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET revoked_at=? WHERE email=? AND revoked_at IS NULL", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, revoked_at, email)
+
+	return err
+}
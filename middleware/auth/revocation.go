@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+type IRevocationStore interface {
+	Revoke(ctx context.Context, jti string, expires_at time.Time) error
+	// RevokeAllForEmail revokes every token of email issued before the given time.
+	RevokeAllForEmail(ctx context.Context, email string, before time.Time) error
+	IsRevoked(ctx context.Context, claims *JwtClaims) (bool, error)
+}
+
+var revocation_store IRevocationStore = NewMemoryRevocationStore()
+
+func SetRevocationStore(store IRevocationStore) {
+	revocation_store = store
+}
+
+// RevokeAllTokens signs email out everywhere: every session ends, every
+// access token issued so far and every refresh token family stop being
+// accepted.
+func RevokeAllTokens(ctx context.Context, email string) error {
+	now := time.Now()
+
+	err := revocation_store.RevokeAllForEmail(ctx, email, now)
+	if err != nil {
+		return err
+	}
+
+	err = session_store.EndAllForEmail(ctx, email, now)
+	if err != nil {
+		return err
+	}
+
+	return refresh_token_store.RevokeAllForEmail(ctx, email, now)
+}
+
+// RevokeToken stops the single token claims belongs to from being accepted.
+func RevokeToken(ctx context.Context, claims *JwtClaims) error {
+	expires_at := time.Now().Add(access_token_lifetime)
+	if claims.ExpiresAt != nil {
+		expires_at = claims.ExpiresAt.Time
+	}
+
+	return revocation_store.Revoke(ctx, claims.ID, expires_at)
+}
+
+// UseMillisecondTimestamps makes the tokens issued from now on carry iat,
+// exp and nbf with millisecond precision instead of whole seconds. A token
+// issued in the same second as a logout-all or a password change is then told
+// apart from the ones the cutoff revokes; with whole seconds, tokens without
+// a session issued earlier in that second stay accepted.
+//
+// It changes the format of every token, including those checked by others
+// against the JWKS: the dates become fractional numbers, which RFC 7519
+// allows but some JWT libraries refuse. It sets jwt.TimePrecision, so it
+// also affects any other token signed with jwt in the program.
+func UseMillisecondTimestamps() {
+	jwt.TimePrecision = time.Millisecond
+}
+
+func issuedBefore(claims *JwtClaims, cutoff time.Time) bool {
+	if claims.IssuedAt == nil {
+		return true
+	}
+
+	// iat only keeps jwt.TimePrecision, a token issued right after the
+	// cutoff must not be mistaken for one issued before it, see
+	// UseMillisecondTimestamps
+	return claims.IssuedAt.Time.Before(cutoff.Truncate(jwt.TimePrecision))
+}
+
+type memoryRevocationStore struct {
+	mutex   sync.Mutex
+	tokens  map[string]time.Time
+	cutoffs map[string]time.Time
+}
+
+func NewMemoryRevocationStore() IRevocationStore {
+	return &memoryRevocationStore{
+		tokens:  map[string]time.Time{},
+		cutoffs: map[string]time.Time{},
+	}
+}
+
+func (store *memoryRevocationStore) Revoke(ctx context.Context, jti string, expires_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	for revoked_jti, revoked_expires_at := range store.tokens {
+		if now.After(revoked_expires_at) {
+			delete(store.tokens, revoked_jti)
+		}
+	}
+
+	store.tokens[jti] = expires_at
+
+	return nil
+}
+
+func (store *memoryRevocationStore) RevokeAllForEmail(ctx context.Context, email string, before time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.cutoffs[email] = before
+
+	return nil
+}
+
+func (store *memoryRevocationStore) IsRevoked(ctx context.Context, claims *JwtClaims) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.tokens[claims.ID]
+	if ok {
+		return true, nil
+	}
+
+	cutoff, ok := store.cutoffs[claims.Email]
+	if ok && issuedBefore(claims, cutoff) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+type mysqlRevocationStore struct {
+	database_connection *sql.DB
+	table               string
+	cutoff_table        string
+}
+
+func NewMySQLRevocationStore(db *sql.DB, table_name, cutoff_table_name string) IRevocationStore {
+	return &mysqlRevocationStore{
+		database_connection: db,
+		table:               table_name,
+		cutoff_table:        cutoff_table_name,
+	}
+}
+
+func (store *mysqlRevocationStore) Revoke(ctx context.Context, jti string, expires_at time.Time) error {
+	sql_query := fmt.Sprintf("INSERT IGNORE INTO %s(jti, expires_at) VALUES (?, ?)", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, jti, expires_at)
+
+	return err
+}
+
+func (store *mysqlRevocationStore) RevokeAllForEmail(ctx context.Context, email string, before time.Time) error {
+	sql_query := fmt.Sprintf("INSERT INTO %s(email, revoked_before) VALUES (?, ?) ON DUPLICATE KEY UPDATE revoked_before=VALUES(revoked_before)", store.cutoff_table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, email, before)
+
+	return err
+}
+
+func (store *mysqlRevocationStore) IsRevoked(ctx context.Context, claims *JwtClaims) (bool, error) {
+	var revoked_jti int
+	var cutoff sql.NullTime
+
+	sql_query := fmt.Sprintf(
+		`SELECT
+			(SELECT COUNT(*) FROM %s WHERE jti=?),
+			(SELECT revoked_before FROM %s WHERE email=?)`, store.table, store.cutoff_table)
+	row := store.database_connection.QueryRowContext(ctx, sql_query, claims.ID, claims.Email)
+
+	err := row.Scan(&revoked_jti, &cutoff)
+	if err != nil {
+		return false, err
+	}
+
+	if revoked_jti > 0 {
+		return true, nil
+	}
+
+	if cutoff.Valid && issuedBefore(claims, cutoff.Time) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func Logout(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Content-Type", "application/json")
+	response := response.New()
+
+	claims, err := ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	// API keys and OAuth clients have no session to end, they are revoked
+	// instead, and impersonation tokens share the session of the impersonator
+	if claims.IsDelegated() {
+		response.Message = "token api key, oauth client atau impersonasi tidak dapat logout"
+
+		writer.WriteHeader(http.StatusForbidden)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	// the refresh token is optional, an empty body only ends the access token
+	// and the session it belongs to
+	payload := LogoutPayload{}
+	json.NewDecoder(request.Body).Decode(&payload)
+
+	err = RevokeToken(request.Context(), claims)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	err = endCurrentSession(request.Context(), claims)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	if payload.RefreshToken != "" {
+		err = RevokeRefreshToken(request.Context(), payload.RefreshToken)
+		if err != nil {
+			log.Println(err)
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			writer.Write(response.ToJson())
+
+			return
+		}
+	}
+
+	response.Message = "berhasil logout"
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(response.ToJson())
+}
+
+func LogoutAll(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Content-Type", "application/json")
+	response := response.New()
+
+	claims, err := ExtractAuthClaims(request.Context())
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	// neither may anyone acting on behalf of the user sign it out of its own
+	// devices
+	if claims.IsDelegated() {
+		response.Message = "token api key, oauth client atau impersonasi tidak dapat logout dari semua perangkat"
+
+		writer.WriteHeader(http.StatusForbidden)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	err = RevokeAllTokens(request.Context(), claims.Email)
+	if err != nil {
+		log.Println(err)
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write(response.ToJson())
+
+		return
+	}
+
+	response.Message = "berhasil logout dari semua perangkat"
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(response.ToJson())
+}
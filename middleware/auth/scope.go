@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+)
+
+const ScopeCustomersRead string = "customers:read"
+const ScopeCustomersWrite string = "customers:write"
+
+var Scopes = []string{ScopeCustomersRead, ScopeCustomersWrite}
+
+func IsValidScope(scope string) bool {
+	for _, valid_scope := range Scopes {
+		if scope == valid_scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether the token may be used for scope. Tokens issued to
+// users carry no scope and are not limited by it.
+func (claims *JwtClaims) HasScope(scope string) bool {
+	if claims.Scope == "" {
+		return true
+	}
+
+	for _, granted := range strings.Fields(claims.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDelegated reports whether the claims belong to an OAuth client, an API
+// key or an impersonator acting on behalf of the user rather than to the
+// user itself.
+func (claims *JwtClaims) IsDelegated() bool {
+	return claims.ClientId != "" || claims.ApiKeyId != "" || claims.IsImpersonated()
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// session_last_seen_resolution keeps last_seen_at from being written on
+// every single request made with a session.
+const session_last_seen_resolution time.Duration = time.Minute
+
+var ErrSessionNotFound = errors.New("auth: session not found")
+
+// Session is one sign-in of a user. Its id is also the family id of the
+// session's refresh tokens and the sid claim of its access tokens.
+type Session struct {
+	Id         uuid.UUID    `json:"id"`
+	Email      string       `json:"-"`
+	UserAgent  string       `json:"user_agent"`
+	IpAddress  string       `json:"ip_address"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastSeenAt time.Time    `json:"last_seen_at"`
+	EndedAt    sql.NullTime `json:"-"`
+}
+
+type ISessionStore interface {
+	Insert(ctx context.Context, session Session) error
+	FindById(ctx context.Context, id uuid.UUID) (Session, error)
+	// FindActiveForEmail returns the sessions of email that were not ended
+	// and were seen after active_since.
+	FindActiveForEmail(ctx context.Context, email string, active_since time.Time) ([]Session, error)
+	Touch(ctx context.Context, id uuid.UUID, seen_at time.Time) error
+	End(ctx context.Context, id uuid.UUID, ended_at time.Time) error
+	EndAllForEmail(ctx context.Context, email string, ended_at time.Time) error
+}
+
+var session_store ISessionStore = NewMemorySessionStore()
+
+func SetSessionStore(store ISessionStore) {
+	session_store = store
+}
+
+const session_user_agent_max_length int = 255
+
+// StartSession records a new sign-in of email.
+func StartSession(ctx context.Context, email, user_agent, ip_address string) (Session, error) {
+	if len(user_agent) > session_user_agent_max_length {
+		user_agent = user_agent[:session_user_agent_max_length]
+	}
+
+	now := time.Now()
+	session := Session{
+		Id:         uuid.New(),
+		Email:      email,
+		UserAgent:  user_agent,
+		IpAddress:  ip_address,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	err := session_store.Insert(ctx, session)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// ResumeSession returns the session of a refresh token family of email. A
+// family started before sessions were recorded gets its session now, or the
+// access tokens issued with it would be refused right away. Ended sessions
+// and those of other users are reported as not found.
+func ResumeSession(ctx context.Context, email string, id uuid.UUID, user_agent, ip_address string) (Session, error) {
+	session, err := session_store.FindById(ctx, id)
+	if err == nil {
+		if session.Email != email || session.EndedAt.Valid {
+			return Session{}, ErrSessionNotFound
+		}
+
+		return session, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Session{}, err
+	}
+
+	if len(user_agent) > session_user_agent_max_length {
+		user_agent = user_agent[:session_user_agent_max_length]
+	}
+
+	now := time.Now()
+	session = Session{
+		Id:         id,
+		Email:      email,
+		UserAgent:  user_agent,
+		IpAddress:  ip_address,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	err = session_store.Insert(ctx, session)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// ActiveSessions lists where email is currently signed in. A session whose
+// refresh tokens all expired counts as ended.
+func ActiveSessions(ctx context.Context, email string) ([]Session, error) {
+	return session_store.FindActiveForEmail(ctx, email, time.Now().Add(-refresh_token_lifetime))
+}
+
+// EndSession signs email out of one session: its refresh tokens are revoked
+// and its access tokens stop being accepted. Sessions of other users are
+// reported as not found.
+func EndSession(ctx context.Context, email string, id uuid.UUID) error {
+	session, err := session_store.FindById(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+
+		return err
+	}
+
+	if session.Email != email || session.EndedAt.Valid {
+		return ErrSessionNotFound
+	}
+
+	now := time.Now()
+
+	err = session_store.End(ctx, id, now)
+	if err != nil {
+		return err
+	}
+
+	return refresh_token_store.RevokeFamily(ctx, id, now)
+}
+
+// endCurrentSession ends the session claims belong to, if any.
+func endCurrentSession(ctx context.Context, claims *JwtClaims) error {
+	if claims.SessionId == "" {
+		return nil
+	}
+
+	id, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		return nil
+	}
+
+	err = EndSession(ctx, claims.Email, id)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// checkSession reports whether the session of claims is still going on and
+// keeps its last_seen_at up to date. Tokens without a session are not
+// affected.
+func checkSession(ctx context.Context, claims *JwtClaims) (bool, error) {
+	if claims.SessionId == "" {
+		return true, nil
+	}
+
+	id, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		return false, nil
+	}
+
+	session, err := session_store.FindById(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if session.EndedAt.Valid {
+		return false, nil
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= session_last_seen_resolution {
+		err = session_store.Touch(ctx, id, now)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+type memorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[uuid.UUID]Session
+}
+
+func NewMemorySessionStore() ISessionStore {
+	return &memorySessionStore{
+		sessions: map[uuid.UUID]Session{},
+	}
+}
+
+func (store *memorySessionStore) Insert(ctx context.Context, session Session) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sessions[session.Id] = session
+
+	return nil
+}
+
+func (store *memorySessionStore) FindById(ctx context.Context, id uuid.UUID) (Session, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[id]
+	if !ok {
+		return session, sql.ErrNoRows
+	}
+
+	return session, nil
+}
+
+func (store *memorySessionStore) FindActiveForEmail(ctx context.Context, email string, active_since time.Time) ([]Session, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	sessions := []Session{}
+	for _, session := range store.sessions {
+		if session.Email == email && !session.EndedAt.Valid && session.LastSeenAt.After(active_since) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (store *memorySessionStore) Touch(ctx context.Context, id uuid.UUID, seen_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[id]
+	if ok {
+		session.LastSeenAt = seen_at
+		store.sessions[id] = session
+	}
+
+	return nil
+}
+
+func (store *memorySessionStore) End(ctx context.Context, id uuid.UUID, ended_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[id]
+	if ok && !session.EndedAt.Valid {
+		session.EndedAt = sql.NullTime{Time: ended_at, Valid: true}
+		store.sessions[id] = session
+	}
+
+	return nil
+}
+
+func (store *memorySessionStore) EndAllForEmail(ctx context.Context, email string, ended_at time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for id, session := range store.sessions {
+		if session.Email != email || session.EndedAt.Valid {
+			continue
+		}
+
+		session.EndedAt = sql.NullTime{Time: ended_at, Valid: true}
+		store.sessions[id] = session
+	}
+
+	return nil
+}
+
+type mysqlSessionStore struct {
+	database_connection *sql.DB
+	table               string
+	fields              string
+}
+
+func NewMySQLSessionStore(db *sql.DB, table_name string) ISessionStore {
+	return &mysqlSessionStore{
+		database_connection: db,
+		table:               table_name,
+		fields:              "id, email, user_agent, ip_address, created_at, last_seen_at, ended_at",
+	}
+}
+
+func (store *mysqlSessionStore) Insert(ctx context.Context, session Session) error {
+	sql_query := fmt.Sprintf("INSERT INTO %s(%s) VALUES (?, ?, ?, ?, ?, ?, NULL)", store.table, store.fields)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, session.Id.String(), session.Email, session.UserAgent, session.IpAddress, session.CreatedAt, session.LastSeenAt)
+
+	return err
+}
+
+func (store *mysqlSessionStore) FindById(ctx context.Context, id uuid.UUID) (Session, error) {
+	sql_query := fmt.Sprintf("SELECT %s FROM %s WHERE id=?", store.fields, store.table)
+	row := store.database_connection.QueryRowContext(ctx, sql_query, id.String())
+
+	return scanSession(row)
+}
+
+func (store *mysqlSessionStore) FindActiveForEmail(ctx context.Context, email string, active_since time.Time) ([]Session, error) {
+	sessions := []Session{}
+
+	sql_query := fmt.Sprintf("SELECT %s FROM %s WHERE email=? AND ended_at IS NULL AND last_seen_at>? ORDER BY last_seen_at DESC", store.fields, store.table)
+	rows, err := store.database_connection.QueryContext(ctx, sql_query, email, active_since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (store *mysqlSessionStore) Touch(ctx context.Context, id uuid.UUID, seen_at time.Time) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET last_seen_at=? WHERE id=?", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, seen_at, id.String())
+
+	return err
+}
+
+func (store *mysqlSessionStore) End(ctx context.Context, id uuid.UUID, ended_at time.Time) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET ended_at=? WHERE id=? AND ended_at IS NULL", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, ended_at, id.String())
+
+	return err
+}
+
+func (store *mysqlSessionStore) EndAllForEmail(ctx context.Context, email string, ended_at time.Time) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET ended_at=? WHERE email=? AND ended_at IS NULL", store.table)
+	_, err := store.database_connection.ExecContext(ctx, sql_query, ended_at, email)
+
+	return err
+}
+
+type sessionScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row sessionScanner) (Session, error) {
+	var session Session
+	var id string
+
+	err := row.Scan(&id, &session.Email, &session.UserAgent, &session.IpAddress, &session.CreatedAt, &session.LastSeenAt, &session.EndedAt)
+	if err != nil {
+		return session, err
+	}
+
+	session.Id, err = uuid.Parse(id)
+	if err != nil {
+		return session, err
+	}
+
+	return session, nil
+}
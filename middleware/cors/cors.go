@@ -0,0 +1,216 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware"
+)
+
+type Config struct {
+	// AllowedOrigins are exact origins such as https://app.example.com,
+	// origins with one wildcard such as https://*.example.com, or "*" for
+	// every origin.
+	AllowedOrigins []string
+	// AllowedMethods default to the methods the router allows on the path,
+	// from its Allow header.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers besides the CORS-safelisted
+	// ones, "*" allowing whatever the browser asks for.
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and the Authorization
+	// header. It cannot be combined with the "*" origin, see Validate.
+	AllowCredentials bool
+	// MaxAge is how many seconds a browser may cache a preflight, not sent
+	// when zero.
+	MaxAge int
+}
+
+var ErrCredentialsForAnyOrigin = errors.New("cors: AllowCredentials cannot be used with the \"*\" origin, list the allowed origins instead")
+
+// Validate refuses configs letting every website make requests with the
+// credentials of the user.
+func (config Config) Validate() error {
+	if config.AllowCredentials && contains(config.AllowedOrigins, "*") {
+		return ErrCredentialsForAnyOrigin
+	}
+
+	return nil
+}
+
+// ConfigFromEnv reads the comma separated CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS and CORS_EXPOSED_HEADERS, with
+// CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE. It reports false when no origin
+// is allowed.
+func ConfigFromEnv() (Config, bool) {
+	max_age, _ := strconv.Atoi(os.Getenv("CORS_MAX_AGE"))
+
+	config := Config{
+		AllowedOrigins:   list(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		AllowedMethods:   list(os.Getenv("CORS_ALLOWED_METHODS")),
+		AllowedHeaders:   list(os.Getenv("CORS_ALLOWED_HEADERS")),
+		ExposedHeaders:   list(os.Getenv("CORS_EXPOSED_HEADERS")),
+		AllowCredentials: os.Getenv("CORS_ALLOW_CREDENTIALS") == "true",
+		MaxAge:           max_age,
+	}
+
+	return config, len(config.AllowedOrigins) > 0
+}
+
+func list(value string) []string {
+	values := []string{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			values = append(values, field)
+		}
+	}
+
+	return values
+}
+
+// New returns a middleware adding the CORS headers of config to the
+// responses of allowed origins, and answering their preflight requests with
+// 204. Requests of other origins go on untouched, so the browser blocks
+// them. Handle it with router.Group.Preflight, for the preflight requests
+// of the group's paths to reach it. New returns the error of Validate when
+// config does not pass it.
+func New(config Config) (middleware.Middleware, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+			// the response depends on Origin even when there is none, so
+			// caches never hand a response without CORS headers to a CORS
+			// caller
+			header := writer.Header()
+			header.Add("Vary", "Origin")
+
+			origin := request.Header.Get("Origin")
+			if origin == "" {
+				next(writer, request, params)
+
+				return
+			}
+
+			if !config.allowsOrigin(origin) {
+				next(writer, request, params)
+
+				return
+			}
+
+			request_method := request.Header.Get("Access-Control-Request-Method")
+			if request.Method != http.MethodOptions || request_method == "" {
+				config.allowOrigin(header, origin)
+
+				if len(config.ExposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+
+				next(writer, request, params)
+
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+
+			methods := config.AllowedMethods
+			if len(methods) == 0 {
+				methods = strings.Split(header.Get("Allow"), ", ")
+			}
+
+			request_headers := list(request.Header.Get("Access-Control-Request-Headers"))
+			if !contains(methods, request_method) || !config.allowsHeaders(request_headers) {
+				next(writer, request, params)
+
+				return
+			}
+
+			config.allowOrigin(header, origin)
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			if len(request_headers) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(request_headers, ", "))
+			}
+
+			if config.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+			}
+
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	}, nil
+}
+
+func (config Config) allowOrigin(header http.Header, origin string) {
+	if contains(config.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (config Config) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, allowed := range config.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		// https://*.example.com allows the subdomains of example.com, not
+		// example.com itself
+		star := strings.Index(allowed, "*")
+		if star < 0 {
+			continue
+		}
+
+		prefix, suffix := allowed[:star], allowed[star+1:]
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (config Config) allowsHeaders(headers []string) bool {
+	if contains(config.AllowedHeaders, "*") {
+		return true
+	}
+
+	for _, header := range headers {
+		if !contains(config.AllowedHeaders, header) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contains compares case-insensitively, as methods and header names are
+// compared by browsers.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
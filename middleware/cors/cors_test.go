@@ -0,0 +1,184 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readItems(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+// newRouter handles GET and POST /api/items behind the CORS middleware of
+// config, through a group's Preflight like main does.
+func newRouter(t *testing.T, config Config) *router.Router {
+	t.Helper()
+
+	cors, err := New(config)
+	require.NoError(t, err)
+
+	api_router := router.New()
+	items := api_router.Group("/api/items").Preflight(cors)
+	items.Handle(router.Endpoint{Method: http.MethodGet}, readItems)
+	items.Handle(router.Endpoint{Method: http.MethodPost}, readItems)
+	require.NoError(t, api_router.Err())
+
+	return api_router
+}
+
+func serve(api_router *router.Router, method, origin string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/api/items", nil)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+
+	recorder := httptest.NewRecorder()
+	api_router.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestOrigin(t *testing.T) {
+	api_router := newRouter(t, Config{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		ExposedHeaders: []string{"Retry-After"},
+	})
+
+	for _, test := range []struct {
+		name    string
+		origin  string
+		allowed bool
+	}{
+		{"exact", "https://app.example.com", true},
+		{"exact other case", "https://APP.example.com", true},
+		{"wildcard", "https://tenant.example.org", true},
+		{"wildcard nested", "https://a.tenant.example.org", true},
+		{"wildcard without subdomain", "https://example.org", false},
+		{"other scheme", "http://app.example.com", false},
+		{"other host", "https://evil.com", false},
+		{"suffix of an allowed one", "https://evilapp.example.com", false},
+		{"none", "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(api_router, http.MethodGet, test.origin, nil)
+
+			// refused origins still reach the handler, the browser blocks them
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, []string{"Origin"}, recorder.Header().Values("Vary"))
+
+			if !test.allowed {
+				assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, recorder.Header().Get("Access-Control-Expose-Headers"))
+
+				return
+			}
+
+			assert.Equal(t, test.origin, recorder.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "Retry-After", recorder.Header().Get("Access-Control-Expose-Headers"))
+			assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	api_router := newRouter(t, Config{AllowedOrigins: []string{"*"}})
+
+	recorder := serve(api_router, http.MethodGet, "https://anywhere.com", nil)
+	assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, recorder.Header().Values("Vary"))
+}
+
+func TestPreflight(t *testing.T) {
+	api_router := newRouter(t, Config{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	for _, test := range []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		allowed bool
+	}{
+		{"allowed", "https://app.example.com", http.MethodPost, "authorization, content-type", true},
+		{"no headers", "https://app.example.com", http.MethodGet, "", true},
+		{"method not handled", "https://app.example.com", http.MethodDelete, "", false},
+		{"header not allowed", "https://app.example.com", http.MethodPost, "X-Custom", false},
+		{"origin not allowed", "https://evil.com", http.MethodPost, "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Access-Control-Request-Method", test.method)
+			if test.headers != "" {
+				header.Set("Access-Control-Request-Headers", test.headers)
+			}
+
+			recorder := serve(api_router, http.MethodOptions, test.origin, header)
+			assert.Contains(t, recorder.Header().Values("Vary"), "Origin")
+
+			if !test.allowed {
+				// answered like any OPTIONS request, without CORS headers
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Methods"))
+
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, recorder.Code)
+			assert.Equal(t, test.origin, recorder.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, "GET, HEAD, OPTIONS, POST", recorder.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, test.headers, recorder.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+			assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, recorder.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestPreflightAllowedMethods(t *testing.T) {
+	api_router := newRouter(t, Config{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{http.MethodGet},
+	})
+
+	header := http.Header{}
+	header.Set("Access-Control-Request-Method", http.MethodPost)
+
+	recorder := serve(api_router, http.MethodOptions, "https://app.example.com", header)
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	recorder = serve(api_router, http.MethodOptions, "https://app.example.com", header)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "GET", recorder.Header().Get("Access-Control-Allow-Methods"))
+}
+
+// TestCredentialsForAnyOrigin makes sure every website cannot make requests
+// with the credentials of the user.
+func TestCredentialsForAnyOrigin(t *testing.T) {
+	config := Config{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+
+	assert.ErrorIs(t, config.Validate(), ErrCredentialsForAnyOrigin)
+
+	cors, err := New(config)
+	assert.ErrorIs(t, err, ErrCredentialsForAnyOrigin)
+	assert.Nil(t, cors)
+
+	config.AllowCredentials = false
+	assert.NoError(t, config.Validate())
+}
@@ -0,0 +1,110 @@
+package rbac
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/response"
+)
+
+const RoleAdmin string = "admin"
+const RoleStaff string = "staff"
+const RoleReadOnly string = "read-only"
+
+// roles within an organization, independent of the roles above. An
+// organization admin manages its members and may change every customer of
+// the organization, a member only the customers it created.
+const OrganizationRoleAdmin string = "admin"
+const OrganizationRoleMember string = "member"
+
+const PermCustomersRead string = "customers:read"
+const PermCustomersWrite string = "customers:write"
+const PermCustomersReadAll string = "customers:read:all"
+const PermCustomersWriteAll string = "customers:write:all"
+const PermUsersAdmin string = "users:admin"
+
+var role_permissions = map[string][]string{
+	RoleAdmin:    {PermCustomersRead, PermCustomersWrite, PermCustomersReadAll, PermCustomersWriteAll, PermUsersAdmin},
+	RoleStaff:    {PermCustomersRead, PermCustomersWrite},
+	RoleReadOnly: {PermCustomersRead},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := role_permissions[role]
+
+	return ok
+}
+
+func IsValidOrganizationRole(role string) bool {
+	return role == OrganizationRoleAdmin || role == OrganizationRoleMember
+}
+
+// scopeOf maps a permission to the OAuth scope that covers it, e.g.
+// customers:read:all is covered by customers:read.
+func scopeOf(permission string) string {
+	fields := strings.SplitN(permission, ":", 3)
+	if len(fields) < 2 {
+		return permission
+	}
+
+	return fields[0] + ":" + fields[1]
+}
+
+// Can reports whether one of the token's roles grants permission and, for
+// scoped tokens, whether the scope allows it too.
+func Can(claims *auth.JwtClaims, permission string) bool {
+	if claims == nil || !claims.HasScope(scopeOf(permission)) {
+		return false
+	}
+
+	for _, role := range claims.Roles {
+		for _, granted := range role_permissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// New returns a middleware that only lets requests through when the token
+// holds every one of permissions. It must run after the auth middleware.
+func New(permissions ...string) middleware.Middleware {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+			response := response.New()
+
+			claims, err := auth.ExtractAuthClaims(request.Context())
+			if err != nil {
+				log.Println(err)
+
+				response.Message = http.StatusText(http.StatusUnauthorized)
+
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusUnauthorized)
+				writer.Write(response.ToJson())
+
+				return
+			}
+
+			for _, permission := range permissions {
+				if !Can(claims, permission) {
+					response.Message = "Anda tidak memiliki akses ke sumber daya ini"
+
+					writer.Header().Set("Content-Type", "application/json")
+					writer.WriteHeader(http.StatusForbidden)
+					writer.Write(response.ToJson())
+
+					return
+				}
+			}
+
+			next(writer, request, params)
+		}
+	}
+}
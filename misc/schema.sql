@@ -0,0 +1,201 @@
+CREATE TABLE refresh_token(
+	token_hash CHAR(64) NOT NULL,
+	family_id CHAR(36) NOT NULL,
+	email VARCHAR(100) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP NULL DEFAULT NULL,
+	revoked_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(token_hash),
+	INDEX(family_id),
+	INDEX(email)
+);
+
+CREATE TABLE revoked_token(
+	jti CHAR(36) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(jti),
+	INDEX(expires_at)
+);
+
+CREATE TABLE token_revocation_cutoff(
+	email VARCHAR(100) NOT NULL,
+	revoked_before TIMESTAMP(6) NOT NULL,
+	PRIMARY KEY(email)
+);
+
+CREATE TABLE oauth_client(
+	id BINARY(16) NOT NULL,
+	id_text CHAR(36) NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	scope VARCHAR(255) NOT NULL,
+	owner_email VARCHAR(100) NOT NULL,
+	secret_hash VARCHAR(72) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY(id),
+	UNIQUE(id_text),
+	INDEX(owner_email)
+);
+
+CREATE TABLE oauth_token_log(
+	id BIGINT AUTO_INCREMENT,
+	client_id CHAR(36) NOT NULL,
+	jti CHAR(36) NOT NULL,
+	scope VARCHAR(255) NOT NULL,
+	remote_addr VARCHAR(45) NOT NULL,
+	issued_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(id),
+	INDEX(client_id)
+);
+
+-- roles: admin, staff, read-only. there is no endpoint to promote a user yet:
+-- UPDATE user SET role='admin' WHERE email='...';
+ALTER TABLE user ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'staff';
+
+CREATE TABLE user_token(
+	token_hash CHAR(64) NOT NULL,
+	email VARCHAR(100) NOT NULL,
+	purpose VARCHAR(30) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(token_hash),
+	INDEX(email, purpose)
+);
+
+ALTER TABLE user ADD COLUMN verified_at TIMESTAMP NULL DEFAULT NULL;
+-- accounts created before verification existed stay usable
+UPDATE user SET verified_at=created_at WHERE verified_at IS NULL;
+
+ALTER TABLE user
+	ADD COLUMN totp_secret VARCHAR(255) NULL DEFAULT NULL,
+	ADD COLUMN totp_enabled_at TIMESTAMP NULL DEFAULT NULL,
+	ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE user_recovery_code(
+	user_id CHAR(36) NOT NULL,
+	code_hash CHAR(64) NOT NULL,
+	used_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(user_id, code_hash)
+);
+
+ALTER TABLE user_token ADD COLUMN data VARCHAR(255) NULL DEFAULT NULL AFTER purpose;
+
+-- argon2id hashes with their prefix do not fit the bcrypt sized column
+ALTER TABLE user MODIFY password VARCHAR(255) NOT NULL;
+
+CREATE TABLE api_key(
+	id BINARY(16) NOT NULL,
+	id_text CHAR(36) NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	key_hash CHAR(64) NOT NULL,
+	prefix VARCHAR(16) NOT NULL,
+	scope VARCHAR(255) NOT NULL,
+	owner_email VARCHAR(100) NOT NULL,
+	expires_at TIMESTAMP NULL DEFAULT NULL,
+	last_used_at TIMESTAMP NULL DEFAULT NULL,
+	revoked_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(id),
+	UNIQUE(id_text),
+	UNIQUE(key_hash),
+	INDEX(owner_email)
+);
+
+CREATE TABLE user_session(
+	id CHAR(36) NOT NULL,
+	email VARCHAR(100) NOT NULL,
+	user_agent VARCHAR(255) NOT NULL,
+	ip_address VARCHAR(45) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	ended_at TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY(id),
+	INDEX(email)
+);
+
+-- deactivated users cannot sign in, refresh tokens, or use their api keys
+-- and oauth clients
+ALTER TABLE user ADD COLUMN deactivated_at TIMESTAMP NULL DEFAULT NULL;
+
+CREATE TABLE audit_log(
+	id BIGINT AUTO_INCREMENT,
+	action VARCHAR(50) NOT NULL,
+	actor VARCHAR(100) NOT NULL,
+	subject VARCHAR(100) NOT NULL,
+	method VARCHAR(10) NOT NULL,
+	path VARCHAR(2048) NOT NULL,
+	status_code SMALLINT NOT NULL,
+	remote_addr VARCHAR(45) NOT NULL,
+	detail VARCHAR(512) NOT NULL,
+	created_at TIMESTAMP(3) NOT NULL,
+	PRIMARY KEY(id),
+	INDEX(actor),
+	INDEX(subject)
+);
+
+CREATE TABLE organization(
+	id BINARY(16) NOT NULL,
+	id_text CHAR(36) NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	created_by VARCHAR(100) NOT NULL,
+	PRIMARY KEY(id),
+	UNIQUE(id_text)
+);
+
+CREATE TABLE organization_member(
+	organization_id CHAR(36) NOT NULL,
+	email VARCHAR(100) NOT NULL,
+	role VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(organization_id, email),
+	INDEX(email)
+);
+
+-- organization_id on user is the organization the user currently works in
+ALTER TABLE user ADD COLUMN organization_id CHAR(36) NULL DEFAULT NULL;
+ALTER TABLE customer ADD COLUMN organization_id CHAR(36) NULL DEFAULT NULL, ADD INDEX(organization_id);
+ALTER TABLE api_key ADD COLUMN organization_id CHAR(36) NULL DEFAULT NULL;
+ALTER TABLE oauth_client ADD COLUMN organization_id CHAR(36) NULL DEFAULT NULL;
+
+-- every existing user gets a personal organization holding the customers,
+-- api keys and oauth clients it owns
+UPDATE user SET organization_id=UPPER(UUID()) WHERE organization_id IS NULL;
+INSERT INTO organization(id, id_text, name, created_at, created_by)
+	SELECT unhex(replace(organization_id, '-', '')), organization_id, fullname, NOW(), email FROM user;
+INSERT INTO organization_member(organization_id, email, role, created_at)
+	SELECT organization_id, email, 'admin', NOW() FROM user;
+UPDATE customer a JOIN user b ON b.email=a.created_by SET a.organization_id=b.organization_id;
+UPDATE api_key a JOIN user b ON b.email=a.owner_email SET a.organization_id=b.organization_id;
+UPDATE oauth_client a JOIN user b ON b.email=a.owner_email SET a.organization_id=b.organization_id;
+
+-- customers shared with members of their organization, letting them change
+-- customers they do not own
+CREATE TABLE customer_share(
+	customer_id CHAR(36) NOT NULL,
+	email VARCHAR(100) NOT NULL,
+	access VARCHAR(10) NOT NULL,
+	shared_by VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(customer_id, email),
+	INDEX(email)
+);
+
+CREATE TABLE customer_transfer(
+	id BIGINT AUTO_INCREMENT,
+	customer_id CHAR(36) NOT NULL,
+	from_email VARCHAR(100) NOT NULL,
+	to_email VARCHAR(100) NOT NULL,
+	transferred_by VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(id),
+	INDEX(customer_id)
+);
+
+-- shares only reach members of the customer's organization, those made to
+-- anyone else are dropped
+DELETE s FROM customer_share s JOIN customer c ON c.id_text=s.customer_id
+	WHERE NOT EXISTS (SELECT 1 FROM organization_member m WHERE m.organization_id=c.organization_id AND m.email=s.email);
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+)
+
+// api_key_last_used_resolution keeps last_used_at from being written on
+// every single request made with a key.
+const api_key_last_used_resolution time.Duration = time.Minute
+
+type ApiKey struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name" validate:"required,max=100"`
+	Scopes     []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	Prefix     string     `json:"prefix"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// OrganizationId is the organization the key was made in, requests made
+	// with it work in it.
+	OrganizationId string `json:"organization_id,omitempty"`
+}
+
+type IApiKeyModel interface {
+	Insert(ctx context.Context, name string, scopes []string, expires_at *time.Time) (ApiKey, string, error)
+	SelectAll(ctx context.Context) ([]ApiKey, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Authenticate(ctx context.Context, hash string) (*auth.JwtClaims, error)
+}
+
+type apiKeyModel struct {
+	database_connection *sql.DB
+	table               string
+	fields              string
+}
+
+func NewApiKey(db *sql.DB, table_name string) IApiKeyModel {
+	return &apiKeyModel{
+		database_connection: db,
+		table:               table_name,
+		fields:              "id_text, name, scope, prefix, expires_at, last_used_at, created_at, organization_id",
+	}
+}
+
+// Insert creates a key owned by the authenticated user. The plain key is
+// only returned here, only its sha256 hash is stored.
+func (model *apiKeyModel) Insert(ctx context.Context, name string, scopes []string, expires_at *time.Time) (ApiKey, string, error) {
+	var api_key ApiKey
+
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return api_key, "", err
+	}
+
+	key_str, hash, prefix, err := auth.GenerateApiKey()
+	if err != nil {
+		return api_key, "", err
+	}
+
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return api_key, "", err
+	}
+
+	api_key = ApiKey{
+		Id:             uuid.New(),
+		Name:           name,
+		Scopes:         scopes,
+		Prefix:         prefix,
+		ExpiresAt:      expires_at,
+		CreatedAt:      time.Now().In(loc),
+		OrganizationId: claims.OrganizationId,
+	}
+
+	sql_query := fmt.Sprintf(
+		`INSERT INTO %s(
+			id,
+			id_text,
+			name,
+			key_hash,
+			prefix,
+			scope,
+			owner_email,
+			expires_at,
+			created_at,
+			organization_id
+		)
+		VALUES (
+			unhex(replace(?, '-', '')),
+			UPPER(?),
+			?,
+			?,
+			?,
+			?,
+			?,
+			?,
+			?,
+			NULLIF(UPPER(?), '')
+		)`, model.table)
+
+	_, err = model.database_connection.ExecContext(ctx, sql_query, api_key.Id, api_key.Id.String(), api_key.Name, hash, api_key.Prefix, strings.Join(api_key.Scopes, " "), claims.Email, api_key.ExpiresAt, api_key.CreatedAt, api_key.OrganizationId)
+	if err != nil {
+		return ApiKey{}, "", err
+	}
+
+	return api_key, key_str, nil
+}
+
+func (model *apiKeyModel) SelectAll(ctx context.Context) ([]ApiKey, error) {
+	api_keys := []ApiKey{}
+
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sql_query := fmt.Sprintf("SELECT %s FROM %s WHERE owner_email=? AND revoked_at IS NULL ORDER BY created_at ASC", model.fields, model.table)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		api_key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		api_keys = append(api_keys, api_key)
+	}
+
+	return api_keys, rows.Err()
+}
+
+func (model *apiKeyModel) Delete(ctx context.Context, id uuid.UUID) error {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	sql_query := fmt.Sprintf("UPDATE %s SET revoked_at=? WHERE id_text=? AND owner_email=? AND revoked_at IS NULL", model.table)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, time.Now(), strings.ToUpper(id.String()), claims.Email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Authenticate implements auth.IApiKeyStore. The claims act on behalf of the
+// key's owner with the owner's current role, limited to the key's scope and
+// organization.
+// Keys of deactivated owners are refused.
+func (model *apiKeyModel) Authenticate(ctx context.Context, hash string) (*auth.JwtClaims, error) {
+	var id string
+	var scope string
+	var expires_at sql.NullTime
+	var claims auth.JwtClaims
+	var role string
+	var organization_id sql.NullString
+
+	sql_query := fmt.Sprintf("SELECT a.id_text, a.scope, a.expires_at, a.owner_email, b.role, a.organization_id FROM %s a JOIN user b ON b.email=a.owner_email WHERE a.key_hash=? AND a.revoked_at IS NULL AND b.deactivated_at IS NULL", model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, hash)
+
+	err := row.Scan(&id, &scope, &expires_at, &claims.Email, &role, &organization_id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrInvalidApiKey
+		}
+
+		return nil, err
+	}
+
+	now := time.Now()
+	if expires_at.Valid && now.After(expires_at.Time) {
+		return nil, auth.ErrInvalidApiKey
+	}
+
+	sql_query = fmt.Sprintf("UPDATE %s SET last_used_at=? WHERE key_hash=? AND (last_used_at IS NULL OR last_used_at<?)", model.table)
+	_, err = model.database_connection.ExecContext(ctx, sql_query, now, hash, now.Add(-api_key_last_used_resolution))
+	if err != nil {
+		return nil, err
+	}
+
+	claims.Subject = id
+	claims.ApiKeyId = id
+	claims.Roles = []string{role}
+	claims.Scope = scope
+	claims.OrganizationId = strings.ToLower(organization_id.String)
+
+	return &claims, nil
+}
+
+func scanApiKey(row rowScanner) (ApiKey, error) {
+	var api_key ApiKey
+	var id string
+	var scope string
+	var expires_at sql.NullTime
+	var last_used_at sql.NullTime
+	var organization_id sql.NullString
+
+	err := row.Scan(&id, &api_key.Name, &scope, &api_key.Prefix, &expires_at, &last_used_at, &api_key.CreatedAt, &organization_id)
+	if err != nil {
+		return api_key, err
+	}
+
+	api_key.Id, err = uuid.Parse(id)
+	if err != nil {
+		return api_key, err
+	}
+
+	api_key.Scopes = strings.Fields(scope)
+	api_key.OrganizationId = strings.ToLower(organization_id.String)
+
+	if expires_at.Valid {
+		api_key.ExpiresAt = &expires_at.Time
+	}
+
+	if last_used_at.Valid {
+		api_key.LastUsedAt = &last_used_at.Time
+	}
+
+	return api_key, nil
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Client struct {
+	Id         uuid.UUID `json:"id"`
+	Name       string    `json:"name" validate:"required,max=100"`
+	Scopes     []string  `json:"scopes" validate:"required,min=1,dive,required"`
+	OwnerEmail string    `json:"owner_email"`
+	OwnerRole  string    `json:"-"`
+	// OrganizationId is the organization the client was registered in, its
+	// tokens work in it.
+	OrganizationId string    `json:"organization_id,omitempty"`
+	SecretHash     string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type IClientModel interface {
+	Insert(ctx context.Context, name string, scopes []string) (Client, string, error)
+	SelectAll(ctx context.Context) ([]Client, error)
+	SelectById(ctx context.Context, id uuid.UUID) (Client, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	RecordIssuance(ctx context.Context, client Client, claims *auth.JwtClaims, remote_addr string) error
+}
+
+type clientModel struct {
+	database_connection *sql.DB
+	table               string
+	log_table           string
+	fields              string
+}
+
+func NewClient(db *sql.DB, table_name, log_table_name string) IClientModel {
+	return &clientModel{
+		database_connection: db,
+		table:               table_name,
+		log_table:           log_table_name,
+		fields:              "a.id_text, a.name, a.scope, a.owner_email, a.secret_hash, a.created_at, b.role, a.organization_id",
+	}
+}
+
+// VerifySecret compares secret with the stored hash in constant time.
+func (client Client) VerifySecret(secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) == nil
+}
+
+func (client Client) HasScope(scope string) bool {
+	for _, granted := range client.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Insert registers a client owned by the authenticated user. The plain secret
+// is only returned here, only its bcrypt hash is stored.
+func (model *clientModel) Insert(ctx context.Context, name string, scopes []string) (Client, string, error) {
+	var client Client
+
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return client, "", err
+	}
+
+	secret_bytes := make([]byte, 32)
+	_, err = rand.Read(secret_bytes)
+	if err != nil {
+		return client, "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(secret_bytes)
+	secret_hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return client, "", err
+	}
+
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return client, "", err
+	}
+
+	client = Client{
+		Id:             uuid.New(),
+		Name:           name,
+		Scopes:         scopes,
+		OwnerEmail:     claims.Email,
+		SecretHash:     string(secret_hash),
+		CreatedAt:      time.Now().In(loc),
+		OrganizationId: claims.OrganizationId,
+	}
+
+	sql_query := fmt.Sprintf(
+		`INSERT INTO %s(
+			id,
+			id_text,
+			name,
+			scope,
+			owner_email,
+			secret_hash,
+			created_at,
+			organization_id
+		)
+		VALUES (
+			unhex(replace(?, '-', '')),
+			UPPER(?),
+			?,
+			?,
+			?,
+			?,
+			?,
+			NULLIF(UPPER(?), '')
+		)`, model.table)
+
+	_, err = model.database_connection.ExecContext(ctx, sql_query, client.Id, client.Id.String(), client.Name, strings.Join(client.Scopes, " "), client.OwnerEmail, client.SecretHash, client.CreatedAt, client.OrganizationId)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (model *clientModel) SelectAll(ctx context.Context) ([]Client, error) {
+	clients := []Client{}
+
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sql_query := fmt.Sprintf("SELECT %s FROM %s a JOIN user b ON b.email=a.owner_email WHERE a.owner_email=? AND a.revoked_at IS NULL ORDER BY a.created_at ASC", model.fields, model.table)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// SelectById looks a client up regardless of its owner, the token endpoint
+// uses it before anyone is authenticated. Revoked clients and clients of
+// deactivated users are not returned.
+func (model *clientModel) SelectById(ctx context.Context, id uuid.UUID) (Client, error) {
+	sql_query := fmt.Sprintf("SELECT %s FROM %s a JOIN user b ON b.email=a.owner_email WHERE a.id_text=? AND a.revoked_at IS NULL AND b.deactivated_at IS NULL", model.fields, model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, strings.ToUpper(id.String()))
+
+	return scanClient(row)
+}
+
+func (model *clientModel) Delete(ctx context.Context, id uuid.UUID) error {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	sql_query := fmt.Sprintf("UPDATE %s SET revoked_at=? WHERE id_text=? AND owner_email=? AND revoked_at IS NULL", model.table)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, time.Now(), strings.ToUpper(id.String()), claims.Email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (model *clientModel) RecordIssuance(ctx context.Context, client Client, claims *auth.JwtClaims, remote_addr string) error {
+	sql_query := fmt.Sprintf("INSERT INTO %s(client_id, jti, scope, remote_addr, issued_at) VALUES (?, ?, ?, ?, ?)", model.log_table)
+	_, err := model.database_connection.ExecContext(ctx, sql_query, client.Id.String(), claims.ID, claims.Scope, remote_addr, claims.IssuedAt.Time)
+
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row rowScanner) (Client, error) {
+	var client Client
+	var id string
+	var scope string
+	var organization_id sql.NullString
+
+	err := row.Scan(&id, &client.Name, &scope, &client.OwnerEmail, &client.SecretHash, &client.CreatedAt, &client.OwnerRole, &organization_id)
+	if err != nil {
+		return client, err
+	}
+
+	client.Id, err = uuid.Parse(id)
+	if err != nil {
+		return client, err
+	}
+
+	client.Scopes = strings.Fields(scope)
+	client.OrganizationId = strings.ToLower(organization_id.String)
+
+	return client, nil
+}
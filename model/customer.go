@@ -4,12 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
 )
 
 const Max_limit int = 10
@@ -43,6 +45,12 @@ func (j Date) Format() string {
 	return t.Format("2006-01-02")
 }
 
+// JSONSchema describes Date for package openapi, it is written as a
+// date-time but read as a date.
+func (j Date) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "format": "date"}
+}
+
 type Customer struct {
 	Id          uuid.UUID `json:"id"`
 	Username    string    `json:"username" validate:"required,alphanum,max=100"`
@@ -51,9 +59,40 @@ type Customer struct {
 	Gender      string    `json:"gender" validate:"oneof=male female other"`
 	DateOfBirth Date      `json:"date_of_birth" validate:"daterequired"`
 	CreatedAt   time.Time `json:"created_at"`
-	CreatedBy   string    `json:"created_by"`
+	// CreatedBy is the owner of the customer, it changes hands on Transfer.
+	CreatedBy string `json:"created_by"`
+	// Shared is the access the caller was given by sharing the customer with
+	// it, empty when it was not.
+	Shared string `json:"shared,omitempty"`
+}
+
+const CustomerAccessRead string = "read"
+const CustomerAccessWrite string = "write"
+
+func IsValidCustomerAccess(access string) bool {
+	return access == CustomerAccessRead || access == CustomerAccessWrite
 }
 
+type CustomerShare struct {
+	Email     string    `json:"email"`
+	Access    string    `json:"access"`
+	SharedBy  string    `json:"shared_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CustomerTransfer struct {
+	CustomerId    uuid.UUID `json:"customer_id"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	TransferredBy string    `json:"transferred_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ICustomerModel scopes every method to the caller in the context, see
+// tenantFilter. Customers the caller cannot see do not exist as far as it is
+// concerned: SelectById returns a zero Customer and the other methods taking
+// an id return sql.ErrNoRows. Customers the caller can see but not change
+// make them return ErrCustomerReadOnly.
 type ICustomerModel interface {
 	Insert(ctx context.Context, username, email, fullname, gender string, dob time.Time) (uuid.UUID, error)
 	SelectAll(ctx context.Context) ([]Customer, error)
@@ -62,6 +101,90 @@ type ICustomerModel interface {
 	SelectPrev(ctx context.Context, customer Customer) ([]Customer, error)
 	Update(ctx context.Context, payload Customer) (Customer, error)
 	Delete(ctx context.Context, id uuid.UUID) error
+	SelectShares(ctx context.Context, id uuid.UUID) ([]CustomerShare, error)
+	// Share gives email, a member of the customer's organization, access to
+	// the customer, replacing what it had.
+	Share(ctx context.Context, id uuid.UUID, email, access string) (CustomerShare, error)
+	// Unshare returns ErrShareNotFound when the customer is not shared with
+	// email.
+	Unshare(ctx context.Context, id uuid.UUID, email string) error
+	// Transfer makes email, a member of the customer's organization, its
+	// owner.
+	Transfer(ctx context.Context, id uuid.UUID, email string) (CustomerTransfer, error)
+}
+
+var ErrNoOrganization = errors.New("model: caller is not a member of an organization")
+var ErrCustomerReadOnly = errors.New("model: caller can read the customer but not change it")
+var ErrNothingToUpdate = errors.New("model: no customer field to update")
+var ErrCustomerOwner = errors.New("model: user already owns the customer")
+var ErrUserNotFound = errors.New("model: user not found")
+var ErrShareNotFound = errors.New("model: customer is not shared with the user")
+var ErrNotOrganizationMember = errors.New("model: user is not a member of the customer's organization")
+
+type customerAccess int
+
+// shared_member keeps a share from reaching past the organization of the
+// customer, e.g. after its user was removed from it.
+const shared_member string = "organization_id IN (SELECT m.organization_id FROM organization_member m WHERE m.email=?)"
+
+const (
+	accessRead customerAccess = iota
+	// accessWrite changes the fields of the customer.
+	accessWrite
+	// accessManage deletes, shares and transfers the customer.
+	accessManage
+)
+
+// tenantFilter limits a query to the customers of the caller's organization,
+// and only while the caller is still a member of it, so a removed member's
+// tokens stop working at once. Every member reads the whole customer book;
+// changes are further limited to the caller's own customers unless it is an
+// admin of the organization or one of its roles grants permission over
+// every owner. Customers shared with the caller are let through as well for
+// reading, and for writing when shared with write access, but never managed,
+// and only while the caller is a member of the customer's organization.
+func tenantFilter(claims *auth.JwtClaims, access customerAccess) (string, []interface{}) {
+	organization_id := strings.ToUpper(claims.OrganizationId)
+
+	condition := "organization_id=? AND EXISTS (SELECT 1 FROM organization_member m WHERE m.organization_id=? AND m.email=?)"
+	args := []interface{}{organization_id, organization_id, claims.Email}
+
+	if access != accessRead && !rbac.Can(claims, rbac.PermCustomersWriteAll) {
+		condition += " AND (created_by=? OR EXISTS (SELECT 1 FROM organization_member m WHERE m.organization_id=? AND m.email=? AND m.role=?))"
+		args = append(args, claims.Email, organization_id, claims.Email, rbac.OrganizationRoleAdmin)
+	}
+
+	switch access {
+	case accessRead:
+		condition = "(" + condition + ") OR (EXISTS (SELECT 1 FROM customer_share s WHERE s.customer_id=id_text AND s.email=?) AND " + shared_member + ")"
+		args = append(args, claims.Email, claims.Email)
+	case accessWrite:
+		condition = "(" + condition + ") OR (EXISTS (SELECT 1 FROM customer_share s WHERE s.customer_id=id_text AND s.email=? AND s.access=?) AND " + shared_member + ")"
+		args = append(args, claims.Email, CustomerAccessWrite, claims.Email)
+	}
+
+	return "(" + condition + ")", args
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// writeDenied tells why a change to the customer with id touched no row:
+// ErrCustomerReadOnly when the caller can still read it, sql.ErrNoRows
+// otherwise.
+func writeDenied(ctx context.Context, query queryRower, claims *auth.JwtClaims, id uuid.UUID) error {
+	tenant_condition, tenant_args := tenantFilter(claims, accessRead)
+
+	var found int
+	sql_query := fmt.Sprintf("SELECT 1 FROM customer WHERE id_text=? AND %s", tenant_condition)
+	err := query.QueryRowContext(ctx, sql_query, append([]interface{}{strings.ToUpper(id.String())}, tenant_args...)...).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	return ErrCustomerReadOnly
 }
 
 type customerModel struct {
@@ -70,12 +193,64 @@ type customerModel struct {
 	fields              string
 }
 
+// NewCustomer selects fields with the caller's email as the first argument
+// of the query, it marks the customers shared with the caller.
 func NewCustomer(db *sql.DB, table_name string) ICustomerModel {
 	return &customerModel{
 		table:               table_name,
 		database_connection: db,
-		fields:              "id_text, fullname, gender, email, username, date_of_birth, created_at, created_by",
+		fields:              "id_text, fullname, gender, email, username, date_of_birth, created_at, created_by, (SELECT s.access FROM customer_share s WHERE s.customer_id=id_text AND s.email=?) AS shared",
+	}
+}
+
+func scanCustomer(row rowScanner) (Customer, error) {
+	var customer Customer
+	var id sql.NullString
+	var fullname sql.NullString
+	var gender sql.NullString
+	var email sql.NullString
+	var username sql.NullString
+	var date_of_birth sql.NullTime
+	var shared sql.NullString
+
+	err := row.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &customer.CreatedAt, &customer.CreatedBy, &shared)
+	if err != nil {
+		return customer, err
+	}
+
+	if id.Valid {
+		customer.Id, err = uuid.Parse(id.String)
+		if err != nil {
+			return customer, err
+		}
+	}
+
+	if date_of_birth.Valid {
+		customer.DateOfBirth = Date(date_of_birth.Time)
+	}
+
+	customer.Fullname = fullname.String
+	customer.Gender = gender.String
+	customer.Email = email.String
+	customer.Username = username.String
+	customer.Shared = shared.String
+
+	return customer, nil
+}
+
+func scanCustomers(rows *sql.Rows) ([]Customer, error) {
+	var customers []Customer
+
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		customers = append(customers, customer)
 	}
+
+	return customers, rows.Err()
 }
 
 func (model *customerModel) Insert(ctx context.Context, username, email, fullname, gender string, dob time.Time) (uuid.UUID, error) {
@@ -103,9 +278,10 @@ func (model *customerModel) Insert(ctx context.Context, username, email, fullnam
 			gender,
 			date_of_birth,
 			created_at,
-			created_by
+			created_by,
+			organization_id
 		)
-	VALUES (
+	SELECT
 			unhex(replace(?, '-', '')),
 			UPPER(?),
 			?,
@@ -114,272 +290,113 @@ func (model *customerModel) Insert(ctx context.Context, username, email, fullnam
 			?,
 			?,
 			?,
-			?
-		)`
+			?,
+			UPPER(?)
+		FROM DUAL
+		WHERE EXISTS (SELECT 1 FROM organization_member m WHERE m.organization_id=UPPER(?) AND m.email=?)`
 
 	claims, err := auth.ExtractAuthClaims(ctx)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	_, err = model.database_connection.ExecContext(ctx, sql_query, id, id.String(), username, email, fullname, gender, dob, now, claims.Email)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, id, id.String(), username, email, fullname, gender, dob, now, claims.Email, claims.OrganizationId, claims.OrganizationId, claims.Email)
 	if err != nil {
-		id = uuid.Nil
+		return uuid.Nil, err
 	}
 
-	return id, err
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// the caller works in no organization, or is no longer a member of it
+	if affected == 0 {
+		return uuid.Nil, ErrNoOrganization
+	}
+
+	return id, nil
 }
 
 func (model *customerModel) SelectAll(ctx context.Context) ([]Customer, error) {
-	var customers []Customer
-	var customer Customer
-
 	claims, err := auth.ExtractAuthClaims(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	sql_query := fmt.Sprintf("SELECT %s FROM portfolio.customer a WHERE a.created_by=? ORDER BY fullname ASC LIMIT ?", model.fields)
-	rows, err := model.database_connection.QueryContext(ctx, sql_query, claims.Email, Max_limit+1)
+	tenant_condition, tenant_args := tenantFilter(claims, accessRead)
+
+	sql_query := fmt.Sprintf("SELECT %s FROM portfolio.customer a WHERE %s ORDER BY fullname ASC, id_text ASC LIMIT ?", model.fields, tenant_condition)
+	args := append([]interface{}{claims.Email}, tenant_args...)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, append(args, Max_limit+1)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var id sql.NullString
-		var fullname sql.NullString
-		var gender sql.NullString
-		var email sql.NullString
-		var username sql.NullString
-		var date_of_birth sql.NullTime
-		var created_at time.Time
-		var created_by string
-
-		err = rows.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &created_at, &created_by)
-		if err != nil {
-			return nil, err
-		}
-
-		if id.Valid {
-			customer.Id, err = uuid.Parse(id.String)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		if fullname.Valid {
-			customer.Fullname = fullname.String
-		}
-
-		if gender.Valid {
-			customer.Gender = gender.String
-		}
-
-		if email.Valid {
-			customer.Email = email.String
-		}
-
-		if username.Valid {
-			customer.Username = username.String
-		}
-
-		if date_of_birth.Valid {
-			customer.DateOfBirth = Date(date_of_birth.Time)
-		}
-
-		customer.CreatedAt = created_at
-		customer.CreatedBy = created_by
-
-		customers = append(customers, customer)
-	}
-
-	return customers, nil
+	return scanCustomers(rows)
 }
 
 func (model *customerModel) SelectById(ctx context.Context, id uuid.UUID) (Customer, error) {
-	var customer Customer
-
-	sql_query := fmt.Sprintf("SELECT %s FROM portfolio.customer a WHERE a.id_text=?", model.fields)
-	rows, err := model.database_connection.QueryContext(ctx, sql_query, id)
+	claims, err := auth.ExtractAuthClaims(ctx)
 	if err != nil {
-		return customer, err
+		return Customer{}, err
 	}
-	defer rows.Close()
-
-	if rows.Next() {
-		var id sql.NullString
-		var fullname sql.NullString
-		var gender sql.NullString
-		var email sql.NullString
-		var username sql.NullString
-		var date_of_birth sql.NullTime
-		var created_at time.Time
-		var created_by string
-
-		err := rows.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &created_at, &created_by)
-		if err != nil {
-			return customer, err
-		}
-
-		customer.CreatedAt = created_at
-		customer.CreatedBy = created_by
 
-		if id.Valid {
-			customer.Id, err = uuid.Parse(id.String)
-			if err != nil {
-				return customer, err
-			}
-		}
-
-		if fullname.Valid {
-			customer.Fullname = fullname.String
-		}
+	tenant_condition, tenant_args := tenantFilter(claims, accessRead)
 
-		if gender.Valid {
-			customer.Gender = gender.String
-		}
-
-		if email.Valid {
-			customer.Email = email.String
-		}
-
-		if username.Valid {
-			customer.Username = username.String
-		}
-
-		if date_of_birth.Valid {
-			customer.DateOfBirth = Date(date_of_birth.Time)
-		}
+	sql_query := fmt.Sprintf("SELECT %s FROM portfolio.customer a WHERE a.id_text=? AND %s", model.fields, tenant_condition)
+	args := append([]interface{}{claims.Email, strings.ToUpper(id.String())}, tenant_args...)
+	customer, err := scanCustomer(model.database_connection.QueryRowContext(ctx, sql_query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Customer{}, nil
 	}
 
-	return customer, nil
+	return customer, err
 }
 
 func (model *customerModel) SelectNext(ctx context.Context, customer Customer) ([]Customer, error) {
-	var customers []Customer
-
-	sql_query := fmt.Sprintf("SELECT %s FROM customer WHERE fullname > ? ORDER BY fullname ASC LIMIT ?", model.fields)
-	rows, err := model.database_connection.QueryContext(ctx, sql_query, customer.Fullname, Max_limit+1)
+	claims, err := auth.ExtractAuthClaims(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var id sql.NullString
-		var fullname sql.NullString
-		var gender sql.NullString
-		var email sql.NullString
-		var username sql.NullString
-		var date_of_birth sql.NullTime
-		var created_at time.Time
-		var created_by string
-
-		err = rows.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &created_at, &created_by)
-		if err != nil {
-			return nil, err
-		}
-
-		customer.CreatedAt = created_at
-		customer.CreatedBy = created_by
-
-		if id.Valid {
-			customer.Id, err = uuid.Parse(id.String)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		if fullname.Valid {
-			customer.Fullname = fullname.String
-		}
+	tenant_condition, tenant_args := tenantFilter(claims, accessRead)
 
-		if gender.Valid {
-			customer.Gender = gender.String
-		}
-
-		if email.Valid {
-			customer.Email = email.String
-		}
-
-		if username.Valid {
-			customer.Username = username.String
-		}
-
-		if date_of_birth.Valid {
-			customer.DateOfBirth = Date(date_of_birth.Time)
-		}
-
-		customers = append(customers, customer)
+	// id_text breaks ties between customers with the same fullname, so none
+	// of them is skipped or shown twice across pages
+	sql_query := fmt.Sprintf("SELECT %s FROM customer WHERE (fullname, id_text) > (?, ?) AND %s ORDER BY fullname ASC, id_text ASC LIMIT ?", model.fields, tenant_condition)
+	args := append([]interface{}{claims.Email, customer.Fullname, strings.ToUpper(customer.Id.String())}, tenant_args...)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, append(args, Max_limit+1)...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return customers, nil
+	return scanCustomers(rows)
 }
 
 func (model *customerModel) SelectPrev(ctx context.Context, customer Customer) ([]Customer, error) {
-	var customers []Customer
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant_condition, tenant_args := tenantFilter(claims, accessRead)
 
 	sql_query := fmt.Sprintf(`
 	SELECT b.* FROM (
-		SELECT %s FROM portfolio.customer a WHERE a.fullname < ? ORDER BY a.fullname DESC LIMIT ?
+		SELECT %s FROM portfolio.customer a WHERE (a.fullname, a.id_text) < (?, ?) AND %s ORDER BY a.fullname DESC, a.id_text DESC LIMIT ?
 		) b
-		ORDER BY b.fullname ASC;`, model.fields)
-	rows, err := model.database_connection.QueryContext(ctx, sql_query, customer.Fullname, Max_limit+1)
+		ORDER BY b.fullname ASC, b.id_text ASC;`, model.fields, tenant_condition)
+	args := append([]interface{}{claims.Email, customer.Fullname, strings.ToUpper(customer.Id.String())}, tenant_args...)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, append(args, Max_limit+1)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var id sql.NullString
-		var fullname sql.NullString
-		var gender sql.NullString
-		var email sql.NullString
-		var username sql.NullString
-		var date_of_birth sql.NullTime
-		var created_at time.Time
-		var created_by string
-
-		err = rows.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &created_at, &created_by)
-		if err != nil {
-			return nil, err
-		}
-
-		customer.CreatedAt = created_at
-		customer.CreatedBy = created_by
-
-		if id.Valid {
-			customer.Id, err = uuid.Parse(id.String)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		if fullname.Valid {
-			customer.Fullname = fullname.String
-		}
-
-		if gender.Valid {
-			customer.Gender = gender.String
-		}
-
-		if email.Valid {
-			customer.Email = email.String
-		}
-
-		if username.Valid {
-			customer.Username = username.String
-		}
-
-		if date_of_birth.Valid {
-			customer.DateOfBirth = Date(date_of_birth.Time)
-		}
-
-		customers = append(customers, customer)
-	}
-
-	return customers, nil
+	return scanCustomers(rows)
 }
 
 func (model *customerModel) Update(ctx context.Context, payload Customer) (Customer, error) {
@@ -418,8 +435,14 @@ func (model *customerModel) Update(ctx context.Context, payload Customer) (Custo
 		struct_fields = append(struct_fields, payload.DateOfBirth.Format())
 	}
 
-	struct_fields = append(struct_fields, payload.Id.String())
-	struct_fields = append(struct_fields, claims.Email)
+	if len(fields) == 0 {
+		return updated_customer, ErrNothingToUpdate
+	}
+
+	tenant_condition, tenant_args := tenantFilter(claims, accessWrite)
+
+	struct_fields = append(struct_fields, strings.ToUpper(payload.Id.String()))
+	struct_fields = append(struct_fields, tenant_args...)
 
 	tx, err := model.database_connection.BeginTx(ctx, nil)
 	if err != nil {
@@ -427,79 +450,290 @@ func (model *customerModel) Update(ctx context.Context, payload Customer) (Custo
 	}
 	defer tx.Rollback()
 
-	sql_query := fmt.Sprintf("UPDATE portfolio.%s SET %s WHERE id_text=? AND created_by=?", model.table, strings.Join(fields, ", "))
+	sql_query := fmt.Sprintf("UPDATE portfolio.%s SET %s WHERE id_text=? AND %s", model.table, strings.Join(fields, ", "), tenant_condition)
 	_, err = tx.ExecContext(ctx, sql_query, struct_fields...)
 	if err != nil {
 		return updated_customer, err
 	}
 
-	sql_query = fmt.Sprintf("SELECT %s FROM portfolio.%s WHERE id_text=? AND created_by=?", model.fields, model.table)
-	row := tx.QueryRowContext(ctx, sql_query, payload.Id.String(), claims.Email)
+	sql_query = fmt.Sprintf("SELECT %s FROM portfolio.%s WHERE id_text=? AND %s", model.fields, model.table, tenant_condition)
+	args := append([]interface{}{claims.Email, strings.ToUpper(payload.Id.String())}, tenant_args...)
+	updated_customer, err = scanCustomer(tx.QueryRowContext(ctx, sql_query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return updated_customer, writeDenied(ctx, tx, claims, payload.Id)
+		}
 
-	var id sql.NullString
-	var fullname sql.NullString
-	var gender sql.NullString
-	var email sql.NullString
-	var username sql.NullString
-	var date_of_birth sql.NullTime
-	var created_at time.Time
-	var created_by string
+		return updated_customer, err
+	}
 
-	err = row.Scan(&id, &fullname, &gender, &email, &username, &date_of_birth, &created_at, &created_by)
+	err = tx.Commit()
 	if err != nil {
 		return updated_customer, err
+
 	}
 
-	if id.Valid {
-		updated_customer.Id, err = uuid.Parse(id.String)
+	return updated_customer, nil
+}
+
+func (model *customerModel) Delete(ctx context.Context, id uuid.UUID) error {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	tenant_condition, tenant_args := tenantFilter(claims, accessManage)
+
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql_query := fmt.Sprintf("DELETE FROM portfolio.%s WHERE id_text=? AND %s", model.table, tenant_condition)
+	result, err := tx.ExecContext(ctx, sql_query, append([]interface{}{strings.ToUpper(id.String())}, tenant_args...)...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return writeDenied(ctx, tx, claims, id)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM customer_share WHERE customer_id=?", strings.ToUpper(id.String()))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockManaged locks the customer with id for a caller allowed to manage it
+// and returns the organization and the owner of the customer.
+func (model *customerModel) lockManaged(ctx context.Context, tx *sql.Tx, claims *auth.JwtClaims, id uuid.UUID) (string, string, error) {
+	var organization_id string
+	var owner string
+
+	tenant_condition, tenant_args := tenantFilter(claims, accessManage)
+
+	sql_query := fmt.Sprintf("SELECT organization_id, created_by FROM %s WHERE id_text=? AND %s FOR UPDATE", model.table, tenant_condition)
+	row := tx.QueryRowContext(ctx, sql_query, append([]interface{}{strings.ToUpper(id.String())}, tenant_args...)...)
+
+	err := row.Scan(&organization_id, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", writeDenied(ctx, tx, claims, id)
+	}
+
+	return organization_id, owner, err
+}
+
+func (model *customerModel) SelectShares(ctx context.Context, id uuid.UUID) ([]CustomerShare, error) {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, _, err = model.lockManaged(ctx, tx, claims, id)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := []CustomerShare{}
+
+	rows, err := tx.QueryContext(ctx, "SELECT email, access, shared_by, created_at FROM customer_share WHERE customer_id=? ORDER BY created_at ASC", strings.ToUpper(id.String()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var share CustomerShare
+
+		err = rows.Scan(&share.Email, &share.Access, &share.SharedBy, &share.CreatedAt)
 		if err != nil {
-			return updated_customer, err
+			return nil, err
 		}
+
+		shares = append(shares, share)
 	}
 
-	if fullname.Valid {
-		updated_customer.Fullname = fullname.String
+	err = rows.Err()
+	if err != nil {
+		return nil, err
 	}
 
-	if gender.Valid {
-		updated_customer.Gender = gender.String
+	return shares, tx.Commit()
+}
+
+func (model *customerModel) Share(ctx context.Context, id uuid.UUID, email, access string) (CustomerShare, error) {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return CustomerShare{}, err
 	}
 
-	if email.Valid {
-		updated_customer.Email = email.String
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return CustomerShare{}, err
 	}
 
-	if username.Valid {
-		updated_customer.Username = username.String
+	share := CustomerShare{
+		Email:     email,
+		Access:    access,
+		SharedBy:  claims.Email,
+		CreatedAt: time.Now().In(loc),
 	}
 
-	if date_of_birth.Valid {
-		updated_customer.DateOfBirth = Date(date_of_birth.Time)
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return CustomerShare{}, err
 	}
+	defer tx.Rollback()
 
-	updated_customer.CreatedAt = created_at
-	updated_customer.CreatedBy = created_by
+	organization_id, owner, err := model.lockManaged(ctx, tx, claims, id)
+	if err != nil {
+		return CustomerShare{}, err
+	}
 
-	err = tx.Commit()
+	if strings.EqualFold(owner, email) {
+		return CustomerShare{}, ErrCustomerOwner
+	}
+
+	var found int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM user WHERE email=?", email).Scan(&found)
 	if err != nil {
-		return updated_customer, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return CustomerShare{}, ErrUserNotFound
+		}
 
+		return CustomerShare{}, err
 	}
 
-	return updated_customer, nil
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM organization_member WHERE organization_id=? AND email=?", organization_id, email).Scan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CustomerShare{}, ErrNotOrganizationMember
+		}
+
+		return CustomerShare{}, err
+	}
+
+	sql_query := `INSERT INTO customer_share(customer_id, email, access, shared_by, created_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE access=VALUES(access), shared_by=VALUES(shared_by), created_at=VALUES(created_at)`
+	_, err = tx.ExecContext(ctx, sql_query, strings.ToUpper(id.String()), share.Email, share.Access, share.SharedBy, share.CreatedAt)
+	if err != nil {
+		return CustomerShare{}, err
+	}
+
+	return share, tx.Commit()
 }
 
-func (model *customerModel) Delete(ctx context.Context, id uuid.UUID) error {
+func (model *customerModel) Unshare(ctx context.Context, id uuid.UUID, email string) error {
 	claims, err := auth.ExtractAuthClaims(ctx)
 	if err != nil {
 		return err
 	}
 
-	sql_query := fmt.Sprintf("DELETE FROM portfolio.%s a WHERE a.id_text=? AND a.created_by=?", model.table)
-	_, err = model.database_connection.ExecContext(ctx, sql_query, id, claims.Email)
+	tx, err := model.database_connection.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	_, _, err = model.lockManaged(ctx, tx, claims, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM customer_share WHERE customer_id=? AND email=?", strings.ToUpper(id.String()), email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrShareNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Transfer drops what was shared with the new owner, it has full access now.
+// The previous owner keeps reading the customer through its organization.
+func (model *customerModel) Transfer(ctx context.Context, id uuid.UUID, email string) (CustomerTransfer, error) {
+	claims, err := auth.ExtractAuthClaims(ctx)
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+	defer tx.Rollback()
+
+	organization_id, owner, err := model.lockManaged(ctx, tx, claims, id)
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	if strings.EqualFold(owner, email) {
+		return CustomerTransfer{}, ErrCustomerOwner
+	}
+
+	var found int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM organization_member WHERE organization_id=? AND email=?", organization_id, email).Scan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CustomerTransfer{}, ErrNotOrganizationMember
+		}
+
+		return CustomerTransfer{}, err
+	}
+
+	transfer := CustomerTransfer{
+		CustomerId:    id,
+		From:          owner,
+		To:            email,
+		TransferredBy: claims.Email,
+		CreatedAt:     time.Now().In(loc),
+	}
+
+	sql_query := fmt.Sprintf("UPDATE %s SET created_by=? WHERE id_text=?", model.table)
+	_, err = tx.ExecContext(ctx, sql_query, transfer.To, strings.ToUpper(id.String()))
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM customer_share WHERE customer_id=? AND email=?", strings.ToUpper(id.String()), transfer.To)
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	sql_query = "INSERT INTO customer_transfer(customer_id, from_email, to_email, transferred_by, created_at) VALUES (?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, sql_query, strings.ToUpper(id.String()), transfer.From, transfer.To, transfer.TransferredBy, transfer.CreatedAt)
+	if err != nil {
+		return CustomerTransfer{}, err
+	}
+
+	return transfer, tx.Commit()
 }
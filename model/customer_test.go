@@ -0,0 +1,174 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/database/databasetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertTestCustomer(t *testing.T, customer_model ICustomerModel, ctx context.Context, fullname string) uuid.UUID {
+	t.Helper()
+
+	id, err := customer_model.Insert(ctx, "customer", "customer@email.com", fullname, "other", time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	return id
+}
+
+func customerIds(customers []Customer) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, customer := range customers {
+		ids = append(ids, customer.Id)
+	}
+
+	return ids
+}
+
+// TestCustomerTenants runs two organizations through every method of
+// ICustomerModel, neither may see or change the customers of the other.
+func TestCustomerTenants(t *testing.T) {
+	db := databasetest.Open(t)
+	customer_model := NewCustomer(db, "customer")
+
+	tenant_a := databasetest.NewTenant(t, db)
+	tenant_b := databasetest.NewTenant(t, db)
+	ctx_a := tenant_a.Context(tenant_a.Admin)
+	ctx_b := tenant_b.Context(tenant_b.Admin)
+
+	id_a := insertTestCustomer(t, customer_model, ctx_a, "Customer A")
+	id_b := insertTestCustomer(t, customer_model, ctx_b, "Customer B")
+
+	t.Run("Insert", func(t *testing.T) {
+		// a token naming an organization the caller is not a member of
+		_, err := customer_model.Insert(tenant_a.Context(tenant_b.Admin), "customer", "customer@email.com", "Customer", "other", time.Now())
+		assert.ErrorIs(t, err, ErrNoOrganization)
+	})
+
+	t.Run("SelectAll", func(t *testing.T) {
+		customers, err := customer_model.SelectAll(ctx_a)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_a}, customerIds(customers))
+
+		customers, err = customer_model.SelectAll(ctx_b)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_b}, customerIds(customers))
+
+		customers, err = customer_model.SelectAll(tenant_a.Context(tenant_b.Admin))
+		require.NoError(t, err)
+		assert.Empty(t, customers)
+	})
+
+	t.Run("SelectById", func(t *testing.T) {
+		customer, err := customer_model.SelectById(ctx_a, id_a)
+		require.NoError(t, err)
+		assert.Equal(t, id_a, customer.Id)
+		assert.Equal(t, tenant_a.Admin, customer.CreatedBy)
+
+		customer, err = customer_model.SelectById(ctx_a, id_b)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Nil, customer.Id)
+	})
+
+	t.Run("SelectNext", func(t *testing.T) {
+		customers, err := customer_model.SelectNext(ctx_a, Customer{})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_a}, customerIds(customers))
+
+		customers, err = customer_model.SelectNext(ctx_b, Customer{})
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_b}, customerIds(customers))
+	})
+
+	t.Run("SelectPrev", func(t *testing.T) {
+		last := Customer{Fullname: "Customer Z"}
+
+		customers, err := customer_model.SelectPrev(ctx_a, last)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_a}, customerIds(customers))
+
+		customers, err = customer_model.SelectPrev(ctx_b, last)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{id_b}, customerIds(customers))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		_, err := customer_model.Update(ctx_a, Customer{Id: id_b, Fullname: "Changed by A"})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		customer, err := customer_model.SelectById(ctx_b, id_b)
+		require.NoError(t, err)
+		assert.Equal(t, "Customer B", customer.Fullname)
+
+		customer, err = customer_model.Update(ctx_a, Customer{Id: id_a, Username: "changed"})
+		require.NoError(t, err)
+		assert.Equal(t, "changed", customer.Username)
+	})
+
+	t.Run("Share", func(t *testing.T) {
+		_, err := customer_model.Share(ctx_a, id_b, tenant_a.Member, CustomerAccessRead)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = customer_model.Share(ctx_a, id_a, tenant_b.Admin, CustomerAccessRead)
+		assert.ErrorIs(t, err, ErrNotOrganizationMember)
+
+		share, err := customer_model.Share(ctx_a, id_a, tenant_a.Member, CustomerAccessWrite)
+		require.NoError(t, err)
+		assert.Equal(t, tenant_a.Admin, share.SharedBy)
+	})
+
+	t.Run("SelectShares", func(t *testing.T) {
+		_, err := customer_model.SelectShares(ctx_b, id_a)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		shares, err := customer_model.SelectShares(ctx_a, id_a)
+		require.NoError(t, err)
+		require.Len(t, shares, 1)
+		assert.Equal(t, tenant_a.Member, shares[0].Email)
+	})
+
+	t.Run("Unshare", func(t *testing.T) {
+		err := customer_model.Unshare(ctx_b, id_a, tenant_a.Member)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		err = customer_model.Unshare(ctx_a, id_a, tenant_a.Member)
+		require.NoError(t, err)
+
+		err = customer_model.Unshare(ctx_a, id_a, tenant_a.Member)
+		assert.ErrorIs(t, err, ErrShareNotFound)
+	})
+
+	t.Run("Transfer", func(t *testing.T) {
+		_, err := customer_model.Transfer(ctx_b, id_a, tenant_b.Admin)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, err = customer_model.Transfer(ctx_a, id_a, tenant_b.Admin)
+		assert.ErrorIs(t, err, ErrNotOrganizationMember)
+
+		transfer, err := customer_model.Transfer(ctx_a, id_a, tenant_a.Member)
+		require.NoError(t, err)
+		assert.Equal(t, tenant_a.Admin, transfer.From)
+		assert.Equal(t, tenant_a.Member, transfer.To)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		err := customer_model.Delete(ctx_a, id_b)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		customer, err := customer_model.SelectById(ctx_b, id_b)
+		require.NoError(t, err)
+		assert.Equal(t, id_b, customer.Id)
+
+		err = customer_model.Delete(ctx_b, id_b)
+		require.NoError(t, err)
+
+		customer, err = customer_model.SelectById(ctx_b, id_b)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Nil, customer.Id)
+	})
+}
@@ -0,0 +1,336 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+)
+
+var ErrMemberExists = errors.New("model: user is already a member of the organization")
+var ErrLastOrganizationAdmin = errors.New("model: organization must keep at least one admin")
+
+type Organization struct {
+	Id   uuid.UUID `json:"id"`
+	Name string    `json:"name" validate:"required,max=100"`
+	// Role is the role of the member the organization was selected for.
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+type OrganizationMember struct {
+	Email     string    `json:"email" validate:"required,email,max=100"`
+	Role      string    `json:"role" validate:"required"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IOrganizationModel interface {
+	// Insert creates an organization with creator_email as its first admin.
+	Insert(ctx context.Context, name, creator_email string) (Organization, error)
+	FindById(ctx context.Context, id uuid.UUID) (Organization, error)
+	// SelectForMember returns the organizations email belongs to, with its
+	// role in each.
+	SelectForMember(ctx context.Context, email string) ([]Organization, error)
+	FindMember(ctx context.Context, id uuid.UUID, email string) (OrganizationMember, error)
+	SelectMembers(ctx context.Context, id uuid.UUID) ([]OrganizationMember, error)
+	// AddMember returns ErrMemberExists when email already belongs to the
+	// organization.
+	AddMember(ctx context.Context, id uuid.UUID, email, role string) (OrganizationMember, error)
+	// UpdateMember and RemoveMember return ErrLastOrganizationAdmin rather
+	// than leave the organization without an admin.
+	UpdateMember(ctx context.Context, id uuid.UUID, email, role string) error
+	RemoveMember(ctx context.Context, id uuid.UUID, email string) error
+}
+
+type organizationModel struct {
+	database_connection *sql.DB
+	table               string
+	member_table        string
+}
+
+func NewOrganization(db *sql.DB, table_name, member_table_name string) IOrganizationModel {
+	return &organizationModel{
+		database_connection: db,
+		table:               table_name,
+		member_table:        member_table_name,
+	}
+}
+
+func (model *organizationModel) Insert(ctx context.Context, name, creator_email string) (Organization, error) {
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return Organization{}, err
+	}
+
+	organization := Organization{
+		Id:        uuid.New(),
+		Name:      name,
+		Role:      rbac.OrganizationRoleAdmin,
+		CreatedAt: time.Now().In(loc),
+		CreatedBy: creator_email,
+	}
+
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	sql_query := fmt.Sprintf(
+		`INSERT INTO %s(
+			id,
+			id_text,
+			name,
+			created_at,
+			created_by
+		)
+		VALUES (
+			unhex(replace(?, '-', '')),
+			UPPER(?),
+			?,
+			?,
+			?
+		)`, model.table)
+
+	_, err = tx.ExecContext(ctx, sql_query, organization.Id, organization.Id.String(), organization.Name, organization.CreatedAt, organization.CreatedBy)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	sql_query = fmt.Sprintf("INSERT INTO %s(organization_id, email, role, created_at) VALUES (UPPER(?), ?, ?, ?)", model.member_table)
+	_, err = tx.ExecContext(ctx, sql_query, organization.Id.String(), creator_email, rbac.OrganizationRoleAdmin, organization.CreatedAt)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Organization{}, err
+	}
+
+	return organization, nil
+}
+
+func (model *organizationModel) FindById(ctx context.Context, id uuid.UUID) (Organization, error) {
+	var organization Organization
+	var id_text string
+
+	sql_query := fmt.Sprintf("SELECT id_text, name, created_at, created_by FROM %s WHERE id_text=?", model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, strings.ToUpper(id.String()))
+
+	err := row.Scan(&id_text, &organization.Name, &organization.CreatedAt, &organization.CreatedBy)
+	if err != nil {
+		return organization, err
+	}
+
+	organization.Id, err = uuid.Parse(id_text)
+
+	return organization, err
+}
+
+func (model *organizationModel) SelectForMember(ctx context.Context, email string) ([]Organization, error) {
+	organizations := []Organization{}
+
+	sql_query := fmt.Sprintf("SELECT a.id_text, a.name, b.role, a.created_at, a.created_by FROM %s a JOIN %s b ON b.organization_id=a.id_text WHERE b.email=? ORDER BY a.name ASC", model.table, model.member_table)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var organization Organization
+		var id_text string
+
+		err = rows.Scan(&id_text, &organization.Name, &organization.Role, &organization.CreatedAt, &organization.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		organization.Id, err = uuid.Parse(id_text)
+		if err != nil {
+			return nil, err
+		}
+
+		organizations = append(organizations, organization)
+	}
+
+	return organizations, rows.Err()
+}
+
+func (model *organizationModel) FindMember(ctx context.Context, id uuid.UUID, email string) (OrganizationMember, error) {
+	var member OrganizationMember
+
+	sql_query := fmt.Sprintf("SELECT email, role, created_at FROM %s WHERE organization_id=? AND email=?", model.member_table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, strings.ToUpper(id.String()), email)
+
+	err := row.Scan(&member.Email, &member.Role, &member.CreatedAt)
+
+	return member, err
+}
+
+func (model *organizationModel) SelectMembers(ctx context.Context, id uuid.UUID) ([]OrganizationMember, error) {
+	members := []OrganizationMember{}
+
+	sql_query := fmt.Sprintf("SELECT email, role, created_at FROM %s WHERE organization_id=? ORDER BY created_at ASC", model.member_table)
+	rows, err := model.database_connection.QueryContext(ctx, sql_query, strings.ToUpper(id.String()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member OrganizationMember
+
+		err = rows.Scan(&member.Email, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+func (model *organizationModel) AddMember(ctx context.Context, id uuid.UUID, email, role string) (OrganizationMember, error) {
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return OrganizationMember{}, err
+	}
+
+	member := OrganizationMember{
+		Email:     email,
+		Role:      role,
+		CreatedAt: time.Now().In(loc),
+	}
+
+	sql_query := fmt.Sprintf("INSERT INTO %s(organization_id, email, role, created_at) VALUES (UPPER(?), ?, ?, ?)", model.member_table)
+	_, err = model.database_connection.ExecContext(ctx, sql_query, id.String(), member.Email, member.Role, member.CreatedAt)
+	if err != nil {
+		mysql_error, ok := err.(*mysql.MySQLError)
+		if ok && mysql_error.Number == 1062 {
+			return OrganizationMember{}, ErrMemberExists
+		}
+
+		return OrganizationMember{}, err
+	}
+
+	return member, nil
+}
+
+// lockAdmins locks the admin rows of the organization and returns how many
+// there are and whether email is one of them.
+func (model *organizationModel) lockAdmins(ctx context.Context, tx *sql.Tx, id uuid.UUID, email string) (int, bool, error) {
+	sql_query := fmt.Sprintf("SELECT email FROM %s WHERE organization_id=? AND role=? FOR UPDATE", model.member_table)
+	rows, err := tx.QueryContext(ctx, sql_query, strings.ToUpper(id.String()), rbac.OrganizationRoleAdmin)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	admin_count := 0
+	is_admin := false
+
+	for rows.Next() {
+		var admin_email string
+
+		err = rows.Scan(&admin_email)
+		if err != nil {
+			return 0, false, err
+		}
+
+		admin_count++
+		if strings.EqualFold(admin_email, email) {
+			is_admin = true
+		}
+	}
+
+	return admin_count, is_admin, rows.Err()
+}
+
+func (model *organizationModel) UpdateMember(ctx context.Context, id uuid.UUID, email, role string) error {
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	admin_count, is_admin, err := model.lockAdmins(ctx, tx, id, email)
+	if err != nil {
+		return err
+	}
+
+	if is_admin && admin_count == 1 && role != rbac.OrganizationRoleAdmin {
+		return ErrLastOrganizationAdmin
+	}
+
+	sql_query := fmt.Sprintf("UPDATE %s SET role=? WHERE organization_id=? AND email=?", model.member_table)
+	result, err := tx.ExecContext(ctx, sql_query, role, strings.ToUpper(id.String()), email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL does not count rows that already held the role
+	if affected == 0 && !is_admin {
+		_, err = model.FindMember(ctx, id, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember also clears the organization as the active one of the user,
+// see User.OrganizationId.
+func (model *organizationModel) RemoveMember(ctx context.Context, id uuid.UUID, email string) error {
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	admin_count, is_admin, err := model.lockAdmins(ctx, tx, id, email)
+	if err != nil {
+		return err
+	}
+
+	if is_admin && admin_count == 1 {
+		return ErrLastOrganizationAdmin
+	}
+
+	sql_query := fmt.Sprintf("DELETE FROM %s WHERE organization_id=? AND email=?", model.member_table)
+	result, err := tx.ExecContext(ctx, sql_query, strings.ToUpper(id.String()), email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user SET organization_id=NULL WHERE email=? AND organization_id=?", email, strings.ToUpper(id.String()))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type IRecoveryCodeModel interface {
+	Replace(ctx context.Context, user_id uuid.UUID, code_hashes []string) error
+	Consume(ctx context.Context, user_id uuid.UUID, code_hash string) (bool, error)
+	DeleteAll(ctx context.Context, user_id uuid.UUID) error
+}
+
+type recoveryCodeModel struct {
+	database_connection *sql.DB
+	table               string
+}
+
+func NewRecoveryCode(db *sql.DB, table_name string) IRecoveryCodeModel {
+	return &recoveryCodeModel{
+		database_connection: db,
+		table:               table_name,
+	}
+}
+
+// Replace drops every recovery code of the user and stores code_hashes in
+// their place.
+func (model *recoveryCodeModel) Replace(ctx context.Context, user_id uuid.UUID, code_hashes []string) error {
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql_query := fmt.Sprintf("DELETE FROM %s WHERE user_id=?", model.table)
+	_, err = tx.ExecContext(ctx, sql_query, user_id.String())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sql_query = fmt.Sprintf("INSERT INTO %s(user_id, code_hash, created_at) VALUES (?, ?, ?)", model.table)
+	for _, code_hash := range code_hashes {
+		_, err = tx.ExecContext(ctx, sql_query, user_id.String(), code_hash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Consume marks an unused code as used and reports whether there was one.
+func (model *recoveryCodeModel) Consume(ctx context.Context, user_id uuid.UUID, code_hash string) (bool, error) {
+	sql_query := fmt.Sprintf("UPDATE %s SET used_at=? WHERE user_id=? AND code_hash=? AND used_at IS NULL", model.table)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, time.Now(), user_id.String(), code_hash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (model *recoveryCodeModel) DeleteAll(ctx context.Context, user_id uuid.UUID) error {
+	sql_query := fmt.Sprintf("DELETE FROM %s WHERE user_id=?", model.table)
+	_, err := model.database_connection.ExecContext(ctx, sql_query, user_id.String())
+
+	return err
+}
@@ -2,86 +2,157 @@ package model
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
+	"github.com/mmiftahrzki/go-rest-api/password"
 )
 
+var ErrUserExists = errors.New("model: user already exists")
+var ErrUserOwnsCustomers = errors.New("model: user still owns customers")
+
 type User struct {
-	Id        uuid.UUID `json:"id"`
-	Email     string    `json:"email" validate:"required,email,max=100"`
-	Password  string    `json:"password,omitempty" validate:"required,max=32"`
-	Fullname  string    `json:"fullname" validate:"required,max=255"`
-	CreatedAt time.Time `json:"created_at"`
-	CreatedBy string    `json:"created_by,omitempty"`
-}
-
-// type IUserModel interface {
-// 	Create(ctx context.Context, id, username, email, fullname, gender, dob string) error
-// 	FindAll(ctx context.Context, max_limit int) ([]User, error)
-// 	FindById(ctx context.Context, id uuid.UUID) (User, error)
-// 	FindAfterId(ctx context.Context, id string, max_limit int) ([]User, error)
-// 	FindBeforeId(ctx context.Context, id string, max_limit int) ([]User, error)
-// }
+	Id                 uuid.UUID  `json:"id"`
+	Email              string     `json:"email" validate:"required,email,max=100"`
+	Password           string     `json:"password,omitempty" validate:"required,max=32"`
+	PasswordHash       string     `json:"-"`
+	Fullname           string     `json:"fullname" validate:"required,max=255"`
+	Role               string     `json:"role,omitempty"`
+	VerifiedAt         *time.Time `json:"verified_at,omitempty"`
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at,omitempty"`
+	// TwoFactorSecret is the encrypted TOTP secret, set from enrolling on.
+	TwoFactorSecret string `json:"-"`
+	// TwoFactorLastStep is the last TOTP step used, older codes are refused.
+	TwoFactorLastStep int64      `json:"-"`
+	DeactivatedAt     *time.Time `json:"deactivated_at,omitempty"`
+	// OrganizationId is the organization the user works in, its tokens are
+	// scoped to it.
+	OrganizationId *uuid.UUID `json:"organization_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+}
 
-type userModel struct {
-	database_connection *sql.DB
-	table               string
-	columns             []string
+// IUserModel is the single way to users. Password always holds a plain
+// password to be hashed, PasswordHash what is stored.
+type IUserModel interface {
+	// Insert hashes user.Password and stores the user. It returns
+	// ErrUserExists when the email is taken.
+	Insert(ctx context.Context, user User) (User, error)
+	FindByEmail(ctx context.Context, email string) (User, error)
+	FindById(ctx context.Context, id uuid.UUID) (User, error)
+	// Update stores email, fullname, role, verified_at, deactivated_at and
+	// organization_id of the user with user.Id. The password is only replaced
+	// when Password is set, so a stale PasswordHash never overwrites a newer
+	// password.
+	Update(ctx context.Context, user User) (User, error)
+	// Rehash replaces the stored hash of user with a fresh hash of
+	// plain_password, unless the password changed since user was loaded.
+	Rehash(ctx context.Context, user User, plain_password string) error
+	// Delete deletes the user with id together with what belongs to it: its
+	// api keys and oauth clients are revoked, its user tokens, recovery
+	// codes, organization memberships and shares are deleted. Its customers
+	// go as deletion says. It returns ErrLastOrganizationAdmin when the user
+	// is the last admin of an organization with other members,
+	// ErrNotOrganizationMember when the new owner is not a member of the
+	// organization of every customer, and ErrUserOwnsCustomers when deletion
+	// says nothing and the user owns customers. The number of customers of
+	// the user is returned with the latter and on success.
+	Delete(ctx context.Context, id uuid.UUID, deletion UserDeletion) (int, error)
+	// ChangeEmail moves the user with old_email to new_email, which counts
+	// as verified. Customers, oauth clients, api keys, organizations,
+	// organization memberships, shares and transfers record their user by
+	// email, they move along. It returns ErrUserExists when new_email is
+	// taken.
+	ChangeEmail(ctx context.Context, old_email, new_email string) error
+	// SetTwoFactorSecret stores the encrypted TOTP secret of a user enrolling
+	// in 2fa, forgetting the steps used with an earlier secret. The secret of
+	// a user with 2fa enabled is kept.
+	SetTwoFactorSecret(ctx context.Context, id uuid.UUID, encrypted_secret string) error
+	EnableTwoFactor(ctx context.Context, id uuid.UUID) error
+	// DisableTwoFactor turns 2fa off and forgets the secret.
+	DisableTwoFactor(ctx context.Context, id uuid.UUID) error
+	// UseTwoFactorStep records step as the last TOTP step of the user. It
+	// reports false when that step or a later one was used already, so two
+	// requests racing with the same code end with only one success.
+	UseTwoFactorStep(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	// List returns up to limit users created after the user with id after,
+	// oldest first. uuid.Nil starts at the beginning.
+	List(ctx context.Context, after uuid.UUID, limit int) ([]User, error)
 }
 
-func NewUserModel(db *sql.DB, table string) *userModel {
-	if db == nil || table == "" {
-		log.Panicln("new user model: invalid params initial")
-	}
+// UserDeletion is what becomes of the customers of a deleted user.
+type UserDeletion struct {
+	// NewOwner is the email of the user receiving the customers, each
+	// transfer is recorded under DeletedBy.
+	NewOwner string
+	// DeleteCustomers deletes the customers instead.
+	DeleteCustomers bool
+	DeletedBy       string
+}
 
-	sql_query := fmt.Sprintf("SELECT * FROM %s LIMIT 1", table)
-	rows, err := db.Query(sql_query)
-	if err != nil {
-		log.Panicln("new user model: ", err)
-	}
-	defer rows.Close()
+// UserTables names the tables of the other models that hold rows of a user,
+// by its email or its id. Delete and ChangeEmail keep them in step with the
+// user.
+type UserTables struct {
+	Customer           string
+	CustomerShare      string
+	CustomerTransfer   string
+	ApiKey             string
+	OauthClient        string
+	UserToken          string
+	RecoveryCode       string
+	Organization       string
+	OrganizationMember string
+}
 
-	columns, err := rows.Columns()
-	if err != nil {
-		log.Panicln("new user model: ", err)
-	}
+type userModel struct {
+	database_connection *sql.DB
+	table               string
+	tables              UserTables
+	fields              string
+}
 
+func NewUser(db *sql.DB, table_name string, tables UserTables) IUserModel {
 	return &userModel{
 		database_connection: db,
-		table:               table,
-		columns:             columns,
+		table:               table_name,
+		tables:              tables,
+		fields:              "id_text, email, password, fullname, role, verified_at, totp_enabled_at, totp_secret, totp_last_step, deactivated_at, organization_id, created_at",
 	}
 }
 
-func (model *userModel) Insert(ctx context.Context, user User) error {
+func (model *userModel) Insert(ctx context.Context, user User) (User, error) {
 	loc, err := time.LoadLocation("Asia/Jakarta")
 	if err != nil {
-		return err
+		return User{}, err
 	}
-	id := uuid.New()
-	now := time.Now().In(loc)
-	hmac_sha256 := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET_KEY")))
-	hmac_sha256.Write([]byte(user.Password))
 
-	password, err := bcrypt.GenerateFromPassword(hmac_sha256.Sum(nil), 12)
+	user.PasswordHash, err = password.Hash(user.Password)
 	if err != nil {
-		log.Println(err)
-
-		return err
+		return User{}, err
 	}
 
+	user.Id = uuid.New()
+	user.Password = ""
+	user.CreatedAt = time.Now().In(loc)
+
 	sql_query := fmt.Sprintf(
-		`INSERT INTO
-			%s (%s)
+		`INSERT INTO %s(
+			id,
+			id_text,
+			email,
+			password,
+			fullname,
+			role,
+			verified_at,
+			created_at
+		)
 		VALUES (
 			unhex(replace(?, '-', '')),
 			UPPER(?),
@@ -89,147 +160,399 @@ func (model *userModel) Insert(ctx context.Context, user User) error {
 			?,
 			?,
 			?,
+			?,
 			?
-		)`, model.table, strings.Join(model.columns, ", "))
+		)`, model.table)
+
+	_, err = model.database_connection.ExecContext(ctx, sql_query, user.Id, user.Id.String(), user.Email, user.PasswordHash, user.Fullname, user.Role, user.VerifiedAt, user.CreatedAt)
+	if err != nil {
+		mysql_error, ok := err.(*mysql.MySQLError)
+		if ok && mysql_error.Number == 1062 {
+			return User{}, ErrUserExists
+		}
+
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (model *userModel) FindByEmail(ctx context.Context, email string) (User, error) {
+	sql_query := fmt.Sprintf("SELECT %s FROM %s WHERE email=?", model.fields, model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, email)
+
+	return scanUser(row)
+}
+
+func (model *userModel) FindById(ctx context.Context, id uuid.UUID) (User, error) {
+	sql_query := fmt.Sprintf("SELECT %s FROM %s WHERE id_text=?", model.fields, model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, strings.ToUpper(id.String()))
+
+	return scanUser(row)
+}
+
+func (model *userModel) Update(ctx context.Context, user User) (User, error) {
+	var organization_id sql.NullString
+	if user.OrganizationId != nil {
+		organization_id = sql.NullString{String: strings.ToUpper(user.OrganizationId.String()), Valid: true}
+	}
+
+	columns := "email=?, fullname=?, role=?, verified_at=?, deactivated_at=?, organization_id=?"
+	args := []interface{}{user.Email, user.Fullname, user.Role, user.VerifiedAt, user.DeactivatedAt, organization_id}
+
+	if user.Password != "" {
+		password_hash, err := password.Hash(user.Password)
+		if err != nil {
+			return User{}, err
+		}
+
+		user.Password = ""
+		user.PasswordHash = password_hash
 
-	_, err = model.database_connection.ExecContext(ctx, sql_query, id, id.String(), user.Email, string(password), user.Fullname, now)
+		columns += ", password=?"
+		args = append(args, user.PasswordHash)
+	}
+
+	args = append(args, strings.ToUpper(user.Id.String()))
+
+	sql_query := fmt.Sprintf("UPDATE %s SET %s WHERE id_text=?", model.table, columns)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, args...)
 	if err != nil {
-		log.Println(err)
+		mysql_error, ok := err.(*mysql.MySQLError)
+		if ok && mysql_error.Number == 1062 {
+			return User{}, ErrUserExists
+		}
+
+		return User{}, err
+	}
 
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+
+	// MySQL does not count rows that already held these values
+	if affected == 0 {
+		_, err = model.FindById(ctx, user.Id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	return user, nil
+}
+
+func (model *userModel) Rehash(ctx context.Context, user User, plain_password string) error {
+	password_hash, err := password.Hash(plain_password)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	sql_query := fmt.Sprintf("UPDATE %s SET password=? WHERE id_text=? AND password=?", model.table)
+	_, err = model.database_connection.ExecContext(ctx, sql_query, password_hash, strings.ToUpper(user.Id.String()), user.PasswordHash)
+
+	return err
 }
 
-func (model *userModel) FindAll(ctx context.Context, max_limit int) ([]User, error) {
-	var users []User
-	var User User
-	var sql_query string
-	var rows *sql.Rows
-	var err error
+func (model *userModel) Delete(ctx context.Context, id uuid.UUID, deletion UserDeletion) (int, error) {
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	sql_query = fmt.Sprintf("SELECT %s FROM user ORDER BY fullname ASC LIMIT ?", strings.Join(model.columns, ", "))
-	rows, err = model.database_connection.QueryContext(ctx, sql_query, max_limit+1)
+	var email string
+
+	sql_query := fmt.Sprintf("SELECT email FROM %s WHERE id_text=? FOR UPDATE", model.table)
+	err = tx.QueryRowContext(ctx, sql_query, strings.ToUpper(id.String())).Scan(&email)
 	if err != nil {
-		log.Println(err)
+		return 0, err
+	}
 
-		return nil, err
+	// an organization is left to its other members only with an admin among
+	// them, as RemoveMember makes sure
+	var orphaned int
+
+	sql_query = fmt.Sprintf(`SELECT COUNT(*) FROM %[1]s a
+		WHERE a.email=? AND a.role=?
+		AND NOT EXISTS (SELECT 1 FROM %[1]s b WHERE b.organization_id=a.organization_id AND b.email<>a.email AND b.role=?)
+		AND EXISTS (SELECT 1 FROM %[1]s c WHERE c.organization_id=a.organization_id AND c.email<>a.email)
+		FOR UPDATE`, model.tables.OrganizationMember)
+	err = tx.QueryRowContext(ctx, sql_query, email, rbac.OrganizationRoleAdmin, rbac.OrganizationRoleAdmin).Scan(&orphaned)
+	if err != nil {
+		return 0, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		// err = rows.Scan(&User.Id, &User.Fullname, &User.Gender, &User.Email, &User.Username, &User.DateOfBirth, &User.CreatedAt)
+	if orphaned > 0 {
+		return 0, ErrLastOrganizationAdmin
+	}
+
+	var customer_count int
+
+	sql_query = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE created_by=? FOR UPDATE", model.tables.Customer)
+	err = tx.QueryRowContext(ctx, sql_query, email).Scan(&customer_count)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case customer_count == 0:
+	case deletion.NewOwner != "":
+		err = model.reassignCustomers(ctx, tx, email, deletion.NewOwner, deletion.DeletedBy)
+	case deletion.DeleteCustomers:
+		err = model.deleteCustomers(ctx, tx, email)
+	default:
+		return customer_count, ErrUserOwnsCustomers
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, table := range []string{model.tables.ApiKey, model.tables.OauthClient} {
+		sql_query = fmt.Sprintf("UPDATE %s SET revoked_at=? WHERE owner_email=? AND revoked_at IS NULL", table)
+		_, err = tx.ExecContext(ctx, sql_query, now, email)
 		if err != nil {
-			log.Println(err)
+			return 0, err
+		}
+	}
 
-			return nil, err
+	for _, table := range []string{model.tables.UserToken, model.tables.OrganizationMember, model.tables.CustomerShare} {
+		sql_query = fmt.Sprintf("DELETE FROM %s WHERE email=?", table)
+		_, err = tx.ExecContext(ctx, sql_query, email)
+		if err != nil {
+			return 0, err
 		}
+	}
+
+	sql_query = fmt.Sprintf("DELETE FROM %s WHERE user_id=?", model.tables.RecoveryCode)
+	_, err = tx.ExecContext(ctx, sql_query, id.String())
+	if err != nil {
+		return 0, err
+	}
 
-		users = append(users, User)
+	sql_query = fmt.Sprintf("DELETE FROM %s WHERE id_text=?", model.table)
+	_, err = tx.ExecContext(ctx, sql_query, strings.ToUpper(id.String()))
+	if err != nil {
+		return 0, err
 	}
 
-	return users, nil
+	return customer_count, tx.Commit()
 }
 
-// func (model *userModel) FindAfterId(ctx context.Context, id string, max_limit int) ([]User, error) {
-// 	var users []User
-// 	var User User
-// 	var sql_query string
-// 	var rows *sql.Rows
-// 	var err error
+// deleteCustomers deletes every customer of owner and what they were shared
+// with.
+func (model *userModel) deleteCustomers(ctx context.Context, tx *sql.Tx, owner string) error {
+	sql_query := fmt.Sprintf("DELETE FROM %s WHERE customer_id IN (SELECT id_text FROM %s WHERE created_by=?)", model.tables.CustomerShare, model.tables.Customer)
+	_, err := tx.ExecContext(ctx, sql_query, owner)
+	if err != nil {
+		return err
+	}
 
-// 	sql_query = "SELECT fullname FROM user WHERE id_text = ?"
-// 	if err = model.database_connection.QueryRowContext(ctx, sql_query, id).Scan(&User.Fullname); err != nil {
-// 		log.Println(err)
+	sql_query = fmt.Sprintf("DELETE FROM %s WHERE created_by=?", model.tables.Customer)
+	_, err = tx.ExecContext(ctx, sql_query, owner)
 
-// 		return nil, err
-// 	}
+	return err
+}
 
-// 	sql_query = fmt.Sprintf("SELECT %s FROM user WHERE fullname > ? ORDER BY fullname ASC LIMIT ?", model.fields)
-// 	rows, err = model.database_connection.QueryContext(ctx, sql_query, User.Fullname, max_limit+1)
-// 	if err != nil {
-// 		log.Println(err)
+// reassignCustomers hands every customer of from to to, recording each
+// transfer under transferred_by. Like Transfer, it returns
+// ErrNotOrganizationMember when to is not a member of the organization of
+// every one of them, they would be out of its reach otherwise.
+func (model *userModel) reassignCustomers(ctx context.Context, tx *sql.Tx, from, to, transferred_by string) error {
+	var outside int
 
-// 		return nil, err
-// 	}
-// 	defer rows.Close()
+	sql_query := fmt.Sprintf(`SELECT COUNT(*) FROM %s a WHERE a.created_by=?
+		AND NOT EXISTS (SELECT 1 FROM %s m WHERE m.organization_id=a.organization_id AND m.email=?)`, model.tables.Customer, model.tables.OrganizationMember)
+	err := tx.QueryRowContext(ctx, sql_query, from, to).Scan(&outside)
+	if err != nil {
+		return err
+	}
 
-// 	for rows.Next() {
-// 		err = rows.Scan(&User.Id, &User.Fullname, &User.Gender, &User.Email, &User.Username, &User.DateOfBirth, &User.CreatedAt)
-// 		if err != nil {
-// 			log.Println(err)
+	if outside > 0 {
+		return ErrNotOrganizationMember
+	}
+
+	sql_query = fmt.Sprintf("INSERT INTO %s(customer_id, from_email, to_email, transferred_by, created_at) SELECT id_text, created_by, ?, ?, ? FROM %s WHERE created_by=?", model.tables.CustomerTransfer, model.tables.Customer)
+	_, err = tx.ExecContext(ctx, sql_query, to, transferred_by, time.Now(), from)
+	if err != nil {
+		return err
+	}
 
-// 			return nil, err
-// 		}
+	sql_query = fmt.Sprintf("UPDATE %s SET created_by=? WHERE created_by=?", model.tables.Customer)
+	_, err = tx.ExecContext(ctx, sql_query, to, from)
 
-// 		users = append(users, User)
-// 	}
+	return err
+}
 
-// 	return users, nil
-// }
+func (model *userModel) ChangeEmail(ctx context.Context, old_email, new_email string) error {
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-// func (model *userModel) FindBeforeId(ctx context.Context, id string, max_limit int) ([]User, error) {
-// 	var users []User
-// 	var User User
-// 	var sql_query string
-// 	var rows *sql.Rows
-// 	var err error
+	sql_query := fmt.Sprintf("UPDATE %s SET email=?, verified_at=? WHERE email=?", model.table)
+	result, err := tx.ExecContext(ctx, sql_query, new_email, time.Now(), old_email)
+	if err != nil {
+		mysql_error, ok := err.(*mysql.MySQLError)
+		if ok && mysql_error.Number == 1062 {
+			return ErrUserExists
+		}
 
-// 	sql_query = "SELECT fullname FROM user WHERE id_text = ?"
-// 	if err := model.database_connection.QueryRowContext(ctx, sql_query, id).Scan(&User.Fullname); err != nil {
-// 		log.Println(err)
+		return err
+	}
 
-// 		return nil, err
-// 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
 
-// 	sql_query = fmt.Sprintf(`
-// 	SELECT a.* FROM (
-// 		SELECT %s FROM user WHERE fullname < ? ORDER BY fullname DESC LIMIT ?
-// 	) a
-// 	ORDER BY a.fullname ASC;`, model.fields)
-// 	rows, err = model.database_connection.QueryContext(ctx, sql_query, User.Fullname, max_limit+1)
-// 	if err != nil {
-// 		log.Println(err)
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
 
-// 		return nil, err
-// 	}
-// 	defer rows.Close()
+	// every column recording the user by email, the history of shares and
+	// transfers included
+	for _, reference := range []struct {
+		table  string
+		column string
+	}{
+		{model.tables.Customer, "created_by"},
+		{model.tables.OauthClient, "owner_email"},
+		{model.tables.ApiKey, "owner_email"},
+		{model.tables.Organization, "created_by"},
+		{model.tables.OrganizationMember, "email"},
+		{model.tables.CustomerShare, "email"},
+		{model.tables.CustomerShare, "shared_by"},
+		{model.tables.CustomerTransfer, "from_email"},
+		{model.tables.CustomerTransfer, "to_email"},
+		{model.tables.CustomerTransfer, "transferred_by"},
+	} {
+		sql_query = fmt.Sprintf("UPDATE %[1]s SET %[2]s=? WHERE %[2]s=?", reference.table, reference.column)
+		_, err = tx.ExecContext(ctx, sql_query, new_email, old_email)
+		if err != nil {
+			return err
+		}
+	}
 
-// 	for rows.Next() {
-// 		err = rows.Scan(&User.Id, &User.Fullname, &User.Gender, &User.Email, &User.Username, &User.DateOfBirth, &User.CreatedAt)
-// 		if err != nil {
-// 			log.Println(err)
+	return tx.Commit()
+}
 
-// 			return nil, err
-// 		}
+func (model *userModel) SetTwoFactorSecret(ctx context.Context, id uuid.UUID, encrypted_secret string) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET totp_secret=?, totp_last_step=0 WHERE id_text=? AND totp_enabled_at IS NULL", model.table)
+	_, err := model.database_connection.ExecContext(ctx, sql_query, encrypted_secret, strings.ToUpper(id.String()))
 
-// 		users = append(users, User)
-// 	}
+	return err
+}
 
-// 	return users, nil
-// }
+func (model *userModel) EnableTwoFactor(ctx context.Context, id uuid.UUID) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET totp_enabled_at=? WHERE id_text=?", model.table)
+	_, err := model.database_connection.ExecContext(ctx, sql_query, time.Now(), strings.ToUpper(id.String()))
 
-// func (model *userModel) FindById(ctx context.Context, id uuid.UUID) (User, error) {
-// 	var user User
+	return err
+}
 
-// 	sql_query := fmt.Sprintf("SELECT %s FROM user WHERE id_text=?", model.fields)
-// 	rows, err := model.database_connection.QueryContext(ctx, sql_query, id)
-// 	if err != nil {
-// 		log.Println(err)
+func (model *userModel) DisableTwoFactor(ctx context.Context, id uuid.UUID) error {
+	sql_query := fmt.Sprintf("UPDATE %s SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_step=0 WHERE id_text=?", model.table)
+	_, err := model.database_connection.ExecContext(ctx, sql_query, strings.ToUpper(id.String()))
 
-// 		return user, err
-// 	}
-// 	defer rows.Close()
+	return err
+}
 
-// 	if rows.Next() {
-// 		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.Fullname, &user.Gender, &user.DateOfBirth, &user.CreatedAt)
-// 		if err != nil {
-// 			log.Println(err)
+func (model *userModel) UseTwoFactorStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	sql_query := fmt.Sprintf("UPDATE %s SET totp_last_step=? WHERE id_text=? AND totp_last_step<?", model.table)
+	result, err := model.database_connection.ExecContext(ctx, sql_query, step, strings.ToUpper(id.String()), step)
+	if err != nil {
+		return false, err
+	}
 
-// 			return user, err
-// 		}
-// 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
 
-// 	return user, nil
-// }
+	return affected > 0, nil
+}
+
+func (model *userModel) List(ctx context.Context, after uuid.UUID, limit int) ([]User, error) {
+	users := []User{}
+
+	var rows *sql.Rows
+	var err error
+
+	if after == uuid.Nil {
+		sql_query := fmt.Sprintf("SELECT %s FROM %s ORDER BY created_at ASC, id_text ASC LIMIT ?", model.fields, model.table)
+		rows, err = model.database_connection.QueryContext(ctx, sql_query, limit)
+	} else {
+		sql_query := fmt.Sprintf(
+			`SELECT %s FROM %s a
+			JOIN (SELECT created_at AS after_created_at, id_text AS after_id_text FROM %s WHERE id_text=?) b
+			WHERE a.created_at > b.after_created_at OR (a.created_at = b.after_created_at AND a.id_text > b.after_id_text)
+			ORDER BY a.created_at ASC, a.id_text ASC
+			LIMIT ?`, model.fields, model.table, model.table)
+		rows, err = model.database_connection.QueryContext(ctx, sql_query, strings.ToUpper(after.String()), limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var id string
+	var verified_at sql.NullTime
+	var totp_enabled_at sql.NullTime
+	var totp_secret sql.NullString
+	var deactivated_at sql.NullTime
+	var organization_id sql.NullString
+
+	err := row.Scan(&id, &user.Email, &user.PasswordHash, &user.Fullname, &user.Role, &verified_at, &totp_enabled_at, &totp_secret, &user.TwoFactorLastStep, &deactivated_at, &organization_id, &user.CreatedAt)
+	if err != nil {
+		return user, err
+	}
+
+	user.Id, err = uuid.Parse(id)
+	if err != nil {
+		return user, err
+	}
+
+	if verified_at.Valid {
+		user.VerifiedAt = &verified_at.Time
+	}
+
+	if totp_enabled_at.Valid {
+		user.TwoFactorEnabledAt = &totp_enabled_at.Time
+	}
+
+	user.TwoFactorSecret = totp_secret.String
+
+	if deactivated_at.Valid {
+		user.DeactivatedAt = &deactivated_at.Time
+	}
+
+	if organization_id.Valid {
+		organization_uuid, err := uuid.Parse(organization_id.String)
+		if err != nil {
+			return user, err
+		}
+
+		user.OrganizationId = &organization_uuid
+	}
+
+	return user, nil
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/mmiftahrzki/go-rest-api/database/databasetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var test_user_tables = UserTables{
+	Customer:           "customer",
+	CustomerShare:      "customer_share",
+	CustomerTransfer:   "customer_transfer",
+	ApiKey:             "api_key",
+	OauthClient:        "oauth_client",
+	UserToken:          "user_token",
+	RecoveryCode:       "user_recovery_code",
+	Organization:       "organization",
+	OrganizationMember: "organization_member",
+}
+
+// TestUserDeleteReassign hands the customers of a deleted user only to a
+// member of their organization.
+func TestUserDeleteReassign(t *testing.T) {
+	db := databasetest.Open(t)
+	user_model := NewUser(db, "user", test_user_tables)
+	customer_model := NewCustomer(db, "customer")
+
+	tenant_a := databasetest.NewTenant(t, db)
+	tenant_b := databasetest.NewTenant(t, db)
+	ctx_a := tenant_a.Context(tenant_a.Admin)
+
+	id := insertTestCustomer(t, customer_model, tenant_a.Context(tenant_a.Member), "Customer A")
+
+	member, err := user_model.FindByEmail(ctx_a, tenant_a.Member)
+	require.NoError(t, err)
+
+	_, err = user_model.Delete(ctx_a, member.Id, UserDeletion{NewOwner: tenant_b.Admin, DeletedBy: tenant_a.Admin})
+	assert.ErrorIs(t, err, ErrNotOrganizationMember)
+
+	customer, err := customer_model.SelectById(ctx_a, id)
+	require.NoError(t, err)
+	assert.Equal(t, tenant_a.Member, customer.CreatedBy)
+
+	customer_count, err := user_model.Delete(ctx_a, member.Id, UserDeletion{NewOwner: tenant_a.Admin, DeletedBy: tenant_a.Admin})
+	require.NoError(t, err)
+	assert.Equal(t, 1, customer_count)
+
+	customer, err = customer_model.SelectById(ctx_a, id)
+	require.NoError(t, err)
+	assert.Equal(t, tenant_a.Admin, customer.CreatedBy)
+
+	var transferred_to string
+	err = db.QueryRow("SELECT to_email FROM customer_transfer WHERE customer_id=UPPER(?)", id.String()).Scan(&transferred_to)
+	require.NoError(t, err)
+	assert.Equal(t, tenant_a.Admin, transferred_to)
+}
+
+// TestUserChangeEmail moves everything recorded under the old email.
+func TestUserChangeEmail(t *testing.T) {
+	db := databasetest.Open(t)
+	user_model := NewUser(db, "user", test_user_tables)
+	customer_model := NewCustomer(db, "customer")
+
+	tenant := databasetest.NewTenant(t, db)
+	ctx := tenant.Context(tenant.Admin)
+
+	owned := insertTestCustomer(t, customer_model, ctx, "Owned")
+	shared := insertTestCustomer(t, customer_model, ctx, "Shared")
+	transferred := insertTestCustomer(t, customer_model, ctx, "Transferred")
+
+	_, err := customer_model.Share(ctx, shared, tenant.Member, CustomerAccessRead)
+	require.NoError(t, err)
+
+	_, err = customer_model.Transfer(ctx, transferred, tenant.Member)
+	require.NoError(t, err)
+
+	new_email := "changed-" + tenant.Admin
+	err = user_model.ChangeEmail(ctx, tenant.Admin, new_email)
+	require.NoError(t, err)
+
+	user, err := user_model.FindByEmail(ctx, new_email)
+	require.NoError(t, err)
+	assert.NotNil(t, user.VerifiedAt)
+
+	for _, test := range []struct {
+		sql_query string
+		arg       string
+	}{
+		{"SELECT created_by FROM customer WHERE id_text=UPPER(?)", owned.String()},
+		{"SELECT shared_by FROM customer_share WHERE customer_id=UPPER(?)", shared.String()},
+		{"SELECT from_email FROM customer_transfer WHERE customer_id=UPPER(?)", transferred.String()},
+		{"SELECT transferred_by FROM customer_transfer WHERE customer_id=UPPER(?)", transferred.String()},
+		{"SELECT created_by FROM organization WHERE id_text=?", tenant.OrganizationId},
+		{"SELECT email FROM organization_member WHERE organization_id=? AND role='admin'", tenant.OrganizationId},
+	} {
+		var email string
+		err = db.QueryRow(test.sql_query, test.arg).Scan(&email)
+		require.NoError(t, err, test.sql_query)
+		assert.Equal(t, new_email, email, test.sql_query)
+	}
+
+	err = user_model.ChangeEmail(ctx, new_email, tenant.Member)
+	assert.ErrorIs(t, err, ErrUserExists)
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mmiftahrzki/go-rest-api/middleware/auth"
+)
+
+const UserTokenPasswordReset string = "password_reset"
+const UserTokenEmailVerification string = "email_verification"
+const UserTokenEmailChange string = "email_change"
+
+var ErrInvalidUserToken = errors.New("model: invalid or expired token")
+
+// UserToken is a single-use token mailed to a user. Only the sha256 hash of
+// the token is stored.
+type UserToken struct {
+	Hash    string
+	Email   string
+	Purpose string
+	// Data carries what the token confirms, such as the new address of an
+	// email change.
+	Data      string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+type IUserTokenModel interface {
+	Insert(ctx context.Context, email, purpose, data string, lifetime time.Duration) (string, error)
+	Consume(ctx context.Context, token_str string, purposes ...string) (UserToken, error)
+	CountSince(ctx context.Context, email, purpose string, since time.Time) (int, time.Time, error)
+}
+
+type userTokenModel struct {
+	database_connection *sql.DB
+	table               string
+}
+
+func NewUserToken(db *sql.DB, table_name string) IUserTokenModel {
+	return &userTokenModel{
+		database_connection: db,
+		table:               table_name,
+	}
+}
+
+// Insert stores a new token for email and returns the plain token, which is
+// only meant to be sent to the user.
+func (model *userTokenModel) Insert(ctx context.Context, email, purpose, data string, lifetime time.Duration) (string, error) {
+	token_str, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	sql_query := fmt.Sprintf("INSERT INTO %s(token_hash, email, purpose, data, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)", model.table)
+	_, err = model.database_connection.ExecContext(ctx, sql_query, hash, email, purpose, data, now.Add(lifetime), now)
+	if err != nil {
+		return "", err
+	}
+
+	return token_str, nil
+}
+
+// Consume marks the token as used and returns it. Unknown, expired, already
+// used tokens and tokens minted for none of purposes all fail with
+// ErrInvalidUserToken.
+func (model *userTokenModel) Consume(ctx context.Context, token_str string, purposes ...string) (UserToken, error) {
+	var token UserToken
+
+	tx, err := model.database_connection.BeginTx(ctx, nil)
+	if err != nil {
+		return token, err
+	}
+	defer tx.Rollback()
+
+	var data sql.NullString
+
+	sql_query := fmt.Sprintf("SELECT token_hash, email, purpose, data, expires_at, used_at, created_at FROM %s WHERE token_hash=? FOR UPDATE", model.table)
+	row := tx.QueryRowContext(ctx, sql_query, auth.HashOpaqueToken(token_str))
+
+	err = row.Scan(&token.Hash, &token.Email, &token.Purpose, &data, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return token, ErrInvalidUserToken
+		}
+
+		return token, err
+	}
+
+	token.Data = data.String
+
+	purpose_matches := false
+	for _, purpose := range purposes {
+		if token.Purpose == purpose {
+			purpose_matches = true
+		}
+	}
+
+	now := time.Now()
+	if !purpose_matches || token.UsedAt.Valid || now.After(token.ExpiresAt) {
+		return token, ErrInvalidUserToken
+	}
+
+	sql_query = fmt.Sprintf("UPDATE %s SET used_at=? WHERE token_hash=?", model.table)
+	_, err = tx.ExecContext(ctx, sql_query, now, token.Hash)
+	if err != nil {
+		return token, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return token, err
+	}
+
+	token.UsedAt = sql.NullTime{Time: now, Valid: true}
+
+	return token, nil
+}
+
+// CountSince returns how many tokens were issued to email for purpose since
+// the given time, and when the latest of them was issued.
+func (model *userTokenModel) CountSince(ctx context.Context, email, purpose string, since time.Time) (int, time.Time, error) {
+	var count int
+	var last_created_at sql.NullTime
+
+	sql_query := fmt.Sprintf("SELECT COUNT(*), MAX(created_at) FROM %s WHERE email=? AND purpose=? AND created_at>=?", model.table)
+	row := model.database_connection.QueryRowContext(ctx, sql_query, email, purpose, since)
+
+	err := row.Scan(&count, &last_created_at)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, last_created_at.Time, nil
+}
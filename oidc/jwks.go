@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwks_refresh_interval limits how often an unknown kid makes the key set be
+// fetched again, a provider rotating its keys is picked up within it.
+const jwks_refresh_interval time.Duration = time.Minute
+
+var errUnknownKey = errors.New("oidc: unknown signing key")
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type keySet struct {
+	mutex      sync.Mutex
+	client     *http.Client
+	url        string
+	keys       map[string]interface{}
+	fetched_at time.Time
+}
+
+func newKeySet(client *http.Client, url string) *keySet {
+	return &keySet{
+		client: client,
+		url:    url,
+		keys:   map[string]interface{}{},
+	}
+}
+
+// find returns the public key kid refers to, fetching the key set again
+// when kid is not known yet.
+func (set *keySet) find(ctx context.Context, kid string, method jwt.SigningMethod) (interface{}, error) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	key, ok := set.keys[kid]
+	if !ok && time.Since(set.fetched_at) >= jwks_refresh_interval {
+		err := set.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok = set.keys[kid]
+	}
+
+	if !ok {
+		return nil, errUnknownKey
+	}
+
+	if !keyMatchesMethod(key, method) {
+		return nil, fmt.Errorf("oidc: key %s cannot verify %s", kid, method.Alg())
+	}
+
+	return key, nil
+}
+
+func (set *keySet) fetch(ctx context.Context) error {
+	json_web_key_set := jsonWebKeySet{}
+
+	err := getJson(ctx, set.client, set.url, &json_web_key_set)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, json_web_key := range json_web_key_set.Keys {
+		if json_web_key.Use != "" && json_web_key.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJsonWebKey(json_web_key)
+		if err != nil {
+			// keys of a type we do not support cannot have signed our tokens
+			continue
+		}
+
+		keys[json_web_key.Kid] = key
+	}
+
+	set.keys = keys
+	set.fetched_at = time.Now()
+
+	return nil
+}
+
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return method == jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		return method == jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		return method == jwt.SigningMethodEdDSA
+	}
+
+	return false
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+func parseJsonWebKey(json_web_key jsonWebKey) (interface{}, error) {
+	switch json_web_key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(json_web_key.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(json_web_key.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if json_web_key.Crv != "P-256" {
+			break
+		}
+
+		x, err := decodeBigInt(json_web_key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(json_web_key.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if json_web_key.Crv != "Ed25519" {
+			break
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(json_web_key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("oidc: unsupported key type %s %s", json_web_key.Kty, json_web_key.Crv)
+}
@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const pending_login_lifetime time.Duration = 10 * time.Minute
+
+var ErrUnknownState = errors.New("oidc: unknown or expired state")
+
+// PendingLogin is what has to be remembered between sending the browser to
+// the provider and its return to the callback.
+type PendingLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type IPendingLoginStore interface {
+	Save(ctx context.Context, login PendingLogin) error
+	// Take returns the login of state and forgets it, so a state can only be
+	// used once. It returns ErrUnknownState when there is none.
+	Take(ctx context.Context, state string) (PendingLogin, error)
+}
+
+var pending_login_store IPendingLoginStore = NewMemoryPendingLoginStore()
+
+func SetPendingLoginStore(store IPendingLoginStore) {
+	pending_login_store = store
+}
+
+// Begin starts a sign-in. It returns the url to send the browser to and the
+// state the callback will be called with.
+func (provider *Provider) Begin(ctx context.Context) (string, string, error) {
+	state, err := GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+
+	code_verifier, err := GenerateCodeVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = pending_login_store.Save(ctx, PendingLogin{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: code_verifier,
+		ExpiresAt:    time.Now().Add(pending_login_lifetime),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return provider.AuthCodeUrl(state, nonce, code_verifier), state, nil
+}
+
+// Finish completes the sign-in started by Begin with the code the provider
+// returned for state.
+func (provider *Provider) Finish(ctx context.Context, state, code string) (*IdTokenClaims, error) {
+	login, err := pending_login_store.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(login.ExpiresAt) {
+		return nil, ErrUnknownState
+	}
+
+	return provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+}
+
+type memoryPendingLoginStore struct {
+	mutex  sync.Mutex
+	logins map[string]PendingLogin
+}
+
+func NewMemoryPendingLoginStore() IPendingLoginStore {
+	return &memoryPendingLoginStore{
+		logins: map[string]PendingLogin{},
+	}
+}
+
+func (store *memoryPendingLoginStore) Save(ctx context.Context, login PendingLogin) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	for state, pending_login := range store.logins {
+		if now.After(pending_login.ExpiresAt) {
+			delete(store.logins, state)
+		}
+	}
+
+	store.logins[login.State] = login
+
+	return nil
+}
+
+func (store *memoryPendingLoginStore) Take(ctx context.Context, state string) (PendingLogin, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	login, ok := store.logins[state]
+	if !ok {
+		return login, ErrUnknownState
+	}
+
+	delete(store.logins, state)
+
+	return login, nil
+}
@@ -0,0 +1,269 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const discovery_path string = "/.well-known/openid-configuration"
+
+var ErrInvalidIdToken = errors.New("oidc: invalid id token")
+
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	// HttpClient is used to talk to the provider, a client with a ten
+	// second timeout when nil.
+	HttpClient *http.Client
+}
+
+// ConfigFromEnv reads the provider settings from the environment.
+//
+//	OIDC_ISSUER          issuer url, discovery is fetched from it
+//	OIDC_CLIENT_ID       client registered at the provider
+//	OIDC_CLIENT_SECRET   secret of that client, empty for public clients
+//	OIDC_REDIRECT_URL    where the provider sends the browser back to, /api/auth/oidc/callback
+//
+// It returns false when OIDC_ISSUER is not set.
+func ConfigFromEnv() (Config, bool) {
+	config := Config{
+		Issuer:       strings.TrimSpace(os.Getenv("OIDC_ISSUER")),
+		ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectUrl:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+
+	return config, config.Issuer != ""
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	config    Config
+	discovery discovery
+	keys      *keySet
+}
+
+type IdTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// NewProvider fetches the provider's discovery document. The issuer it
+// announces must be the configured one.
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.ClientId == "" || config.RedirectUrl == "" {
+		return nil, errors.New("oidc: client id and redirect url are required")
+	}
+
+	if config.HttpClient == nil {
+		config.HttpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	provider := &Provider{config: config}
+
+	discovery_url := strings.TrimSuffix(config.Issuer, "/") + discovery_path
+	err := getJson(ctx, config.HttpClient, discovery_url, &provider.discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery announces issuer %s instead of %s", provider.discovery.Issuer, config.Issuer)
+	}
+
+	if provider.discovery.AuthorizationEndpoint == "" || provider.discovery.TokenEndpoint == "" || provider.discovery.JwksUri == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+
+	provider.keys = newKeySet(config.HttpClient, provider.discovery.JwksUri)
+
+	return provider, nil
+}
+
+// GenerateCodeVerifier returns a PKCE code verifier (RFC 7636).
+func GenerateCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge derives the S256 challenge of verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GenerateState returns a random value for the state or nonce parameter.
+func GenerateState() (string, error) {
+	return randomString(24)
+}
+
+func randomString(length int) (string, error) {
+	buffer := make([]byte, length)
+
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// AuthCodeUrl is where the browser is sent to sign in at the provider.
+func (provider *Provider) AuthCodeUrl(state, nonce, code_verifier string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.config.ClientId)
+	query.Set("redirect_uri", provider.config.RedirectUrl)
+	query.Set("scope", strings.Join(provider.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(code_verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return provider.discovery.AuthorizationEndpoint + separator + query.Encode()
+}
+
+type tokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns
+// the validated claims of the id token it came with.
+func (provider *Provider) Exchange(ctx context.Context, code, code_verifier, nonce string) (*IdTokenClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.config.RedirectUrl)
+	form.Set("code_verifier", code_verifier)
+
+	// public clients identify themselves in the body, confidential ones
+	// authenticate with HTTP Basic
+	if provider.config.ClientSecret == "" {
+		form.Set("client_id", provider.config.ClientId)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if provider.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.config.ClientId), url.QueryEscape(provider.config.ClientSecret))
+	}
+
+	response, err := provider.config.HttpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	token_response := tokenResponse{}
+	err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&token_response)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token endpoint answered %d: %w", response.StatusCode, err)
+	}
+
+	if response.StatusCode != http.StatusOK || token_response.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint answered %d: %s %s", response.StatusCode, token_response.Error, token_response.ErrorDescription)
+	}
+
+	return provider.VerifyIdToken(ctx, token_response.IdToken, nonce)
+}
+
+// VerifyIdToken checks the signature of an id token against the provider's
+// JWKS together with its issuer, audience, expiry and nonce.
+func (provider *Provider) VerifyIdToken(ctx context.Context, id_token, nonce string) (*IdTokenClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}))
+
+	token, err := parser.ParseWithClaims(id_token, &IdTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return provider.keys.find(ctx, kid, token.Method)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIdToken, err)
+	}
+
+	claims, ok := token.Claims.(*IdTokenClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidIdToken
+	}
+
+	if !claims.VerifyIssuer(provider.discovery.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIdToken, claims.Issuer)
+	}
+
+	if !claims.VerifyAudience(provider.config.ClientId, true) {
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIdToken)
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidIdToken)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIdToken)
+	}
+
+	return claims, nil
+}
+
+func getJson(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s answered %d", url, response.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(target)
+}
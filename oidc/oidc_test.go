@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mmiftahrzki/go-rest-api/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const test_client_id string = "go-rest-api"
+const test_client_secret string = "secret"
+const test_redirect_url string = "http://localhost:3000/api/auth/oidc/callback"
+
+func newTestProvider(t *testing.T, client_id string) (*oidctest.Server, *Provider) {
+	t.Helper()
+
+	server, err := oidctest.NewServer(test_client_id, test_client_secret)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	provider, err := NewProvider(context.Background(), Config{
+		Issuer:       server.URL,
+		ClientId:     client_id,
+		ClientSecret: test_client_secret,
+		RedirectUrl:  test_redirect_url,
+	})
+	require.NoError(t, err)
+
+	return server, provider
+}
+
+// redeem exchanges code at the token endpoint of server and returns the raw
+// id token, for checking it with a provider of another client.
+func redeem(t *testing.T, server *oidctest.Server, code, code_verifier string) string {
+	t.Helper()
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", test_redirect_url)
+	form.Set("code_verifier", code_verifier)
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(test_client_id, test_client_secret)
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	token_response := tokenResponse{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&token_response))
+
+	return token_response.IdToken
+}
+
+func TestFinish(t *testing.T) {
+	server, provider := newTestProvider(t, test_client_id)
+	server.SetUser(oidctest.User{Subject: "42", Email: "budi@email.com", EmailVerified: true, Name: "Budi"})
+
+	auth_code_url, state, err := provider.Begin(context.Background())
+	require.NoError(t, err)
+
+	code, returned_state, err := server.Authorize(auth_code_url)
+	require.NoError(t, err)
+	require.Equal(t, state, returned_state)
+
+	claims, err := provider.Finish(context.Background(), state, code)
+	require.NoError(t, err)
+	assert.Equal(t, "budi@email.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, "42", claims.Subject)
+
+	t.Run("reused state", func(t *testing.T) {
+		_, err := provider.Finish(context.Background(), state, code)
+		assert.ErrorIs(t, err, ErrUnknownState)
+	})
+}
+
+func TestExchange(t *testing.T) {
+	server, provider := newTestProvider(t, test_client_id)
+
+	authorize := func(t *testing.T, nonce string) (string, string) {
+		code_verifier, err := GenerateCodeVerifier()
+		require.NoError(t, err)
+
+		code, _, err := server.Authorize(provider.AuthCodeUrl("state", nonce, code_verifier))
+		require.NoError(t, err)
+
+		return code, code_verifier
+	}
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		code, code_verifier := authorize(t, "nonce")
+
+		_, err := provider.Exchange(context.Background(), code, code_verifier, "another nonce")
+		assert.ErrorIs(t, err, ErrInvalidIdToken)
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		code, _ := authorize(t, "nonce")
+
+		another_verifier, err := GenerateCodeVerifier()
+		require.NoError(t, err)
+
+		_, err = provider.Exchange(context.Background(), code, another_verifier, "nonce")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid_grant")
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		code, code_verifier := authorize(t, "nonce")
+		id_token := redeem(t, server, code, code_verifier)
+
+		another_client, err := NewProvider(context.Background(), Config{
+			Issuer:      server.URL,
+			ClientId:    "another-client",
+			RedirectUrl: test_redirect_url,
+		})
+		require.NoError(t, err)
+
+		_, err = another_client.VerifyIdToken(context.Background(), id_token, "nonce")
+		assert.ErrorIs(t, err, ErrInvalidIdToken)
+		assert.Contains(t, err.Error(), "not issued to this client")
+
+		// the token itself is fine for the client it was issued to
+		_, err = provider.VerifyIdToken(context.Background(), id_token, "nonce")
+		assert.NoError(t, err)
+	})
+}
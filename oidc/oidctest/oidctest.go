@@ -0,0 +1,276 @@
+// Package oidctest runs an in-process OpenID Connect provider for testing
+// the oidc package without a real one.
+// It signs in whatever User is configured without asking anything.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const key_id string = "oidctest"
+const id_token_lifetime time.Duration = 5 * time.Minute
+
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type issuedCode struct {
+	client_id      string
+	redirect_uri   string
+	code_challenge string
+	nonce          string
+	user           User
+}
+
+type Server struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+
+	mutex sync.Mutex
+	key   *rsa.PrivateKey
+	user  User
+	codes map[string]issuedCode
+}
+
+// NewServer starts a provider that knows a single client. An empty
+// client_secret makes it a public client.
+func NewServer(client_id, client_secret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		ClientId:     client_id,
+		ClientSecret: client_secret,
+		key:          key,
+		user: User{
+			Subject:       "1",
+			Email:         "oidc@email.com",
+			EmailVerified: true,
+			Name:          "OIDC User",
+		},
+		codes: map[string]issuedCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", server.discovery)
+	mux.HandleFunc("/authorize", server.authorize)
+	mux.HandleFunc("/token", server.token)
+	mux.HandleFunc("/jwks", server.jwks)
+
+	server.Server = httptest.NewServer(mux)
+
+	return server, nil
+}
+
+// SetUser changes who signs in at the next authorization request.
+func (server *Server) SetUser(user User) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.user = user
+}
+
+// Authorize plays the browser: it follows auth_code_url and returns the
+// code and state the provider sends back to the redirect uri.
+func (server *Server) Authorize(auth_code_url string) (string, string, error) {
+	client := &http.Client{
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	response, err := client.Get(auth_code_url)
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusFound {
+		return "", "", errors.New("oidctest: authorization request refused")
+	}
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func randomString() string {
+	buffer := make([]byte, 24)
+	rand.Read(buffer)
+
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
+
+func writeJson(writer http.ResponseWriter, status_code int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status_code)
+	json.NewEncoder(writer).Encode(body)
+}
+
+func (server *Server) discovery(writer http.ResponseWriter, request *http.Request) {
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"issuer":                                server.URL,
+		"authorization_endpoint":                server.URL + "/authorize",
+		"token_endpoint":                        server.URL + "/token",
+		"jwks_uri":                              server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (server *Server) authorize(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	if query.Get("client_id") != server.ClientId || query.Get("redirect_uri") == "" {
+		writeJson(writer, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	redirect_uri, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	redirect_query := redirect_uri.Query()
+	redirect_query.Set("state", query.Get("state"))
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		redirect_query.Set("error", "invalid_request")
+	} else {
+		code := randomString()
+
+		server.mutex.Lock()
+		server.codes[code] = issuedCode{
+			client_id:      server.ClientId,
+			redirect_uri:   query.Get("redirect_uri"),
+			code_challenge: query.Get("code_challenge"),
+			nonce:          query.Get("nonce"),
+			user:           server.user,
+		}
+		server.mutex.Unlock()
+
+		redirect_query.Set("code", code)
+	}
+
+	redirect_uri.RawQuery = redirect_query.Encode()
+
+	http.Redirect(writer, request, redirect_uri.String(), http.StatusFound)
+}
+
+func (server *Server) token(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.ParseForm() != nil {
+		writeJson(writer, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	client_id, client_secret, ok := request.BasicAuth()
+	if ok {
+		client_id, _ = url.QueryUnescape(client_id)
+		client_secret, _ = url.QueryUnescape(client_secret)
+	} else {
+		client_id = request.PostForm.Get("client_id")
+	}
+
+	if client_id != server.ClientId || client_secret != server.ClientSecret {
+		writeJson(writer, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+
+		return
+	}
+
+	if request.PostForm.Get("grant_type") != "authorization_code" {
+		writeJson(writer, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+
+		return
+	}
+
+	code := request.PostForm.Get("code")
+
+	// codes can only be redeemed once, even by a failed attempt
+	server.mutex.Lock()
+	issued, ok := server.codes[code]
+	delete(server.codes, code)
+	server.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(request.PostForm.Get("code_verifier")))
+	if !ok || issued.redirect_uri != request.PostForm.Get("redirect_uri") || base64.RawURLEncoding.EncodeToString(challenge[:]) != issued.code_challenge {
+		writeJson(writer, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            server.URL,
+		"sub":            issued.user.Subject,
+		"aud":            server.ClientId,
+		"iat":            now.Unix(),
+		"exp":            now.Add(id_token_lifetime).Unix(),
+		"nonce":          issued.nonce,
+		"email":          issued.user.Email,
+		"email_verified": issued.user.EmailVerified,
+		"name":           issued.user.Name,
+	}
+
+	id_token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	id_token.Header["kid"] = key_id
+
+	signed_id_token, err := id_token.SignedString(server.key)
+	if err != nil {
+		writeJson(writer, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(id_token_lifetime.Seconds()),
+		"id_token":     signed_id_token,
+	})
+}
+
+func (server *Server) jwks(writer http.ResponseWriter, request *http.Request) {
+	public_key := server.key.PublicKey
+
+	writeJson(writer, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": key_id,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(public_key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public_key.E)).Bytes()),
+			},
+		},
+	})
+}
@@ -0,0 +1,227 @@
+package openapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/response"
+	"github.com/mmiftahrzki/go-rest-api/router"
+)
+
+// security schemes an Endpoint can name in its Security
+const SecurityBearer string = "bearer"
+const SecurityApiKey string = "api_key"
+
+var security_schemes = map[string]interface{}{
+	SecurityBearer: map[string]interface{}{
+		"type":         "http",
+		"scheme":       "bearer",
+		"bearerFormat": "JWT",
+	},
+	SecurityApiKey: map[string]interface{}{
+		"type": "apiKey",
+		"in":   "header",
+		"name": "X-API-Key",
+	},
+}
+
+type Contact struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type Info struct {
+	Title       string   `json:"title"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Contact     *Contact `json:"contact,omitempty"`
+}
+
+// Document builds the OpenAPI 3.1 document of routes. Bodies are described
+// from the json and validate tags of the types in Endpoint.Request and
+// Endpoint.Response, every response is wrapped in the message and data
+// envelope of package response.
+func Document(info Info, routes []router.Route) map[string]interface{} {
+	schemas := newSchemaSet()
+	paths := map[string]interface{}{}
+
+	for _, route := range routes {
+		path, parameters := pathOf(route.Path)
+
+		path_item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			path_item = map[string]interface{}{}
+			paths[path] = path_item
+		}
+
+		path_item[strings.ToLower(route.Method)] = operation(route, parameters, schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    info,
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas":         schemas.components,
+			"securitySchemes": security_schemes,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(envelope(nil)),
+				},
+			},
+		},
+	}
+}
+
+// Handler serves the document of the routes of r as JSON. It is built on the
+// first request, when every route has been handled.
+func Handler(info Info, r *router.Router) httprouter.Handle {
+	var mutex sync.Mutex
+	var document []byte
+
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		writer.Header().Set("Content-Type", "application/json")
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// a failed build is not kept, the next request tries again
+		if document == nil {
+			json_encoded, err := json.Marshal(Document(info, r.Routes()))
+			if err != nil {
+				log.Println(err)
+
+				writer.WriteHeader(http.StatusInternalServerError)
+				writer.Write(response.New().ToJson())
+
+				return
+			}
+
+			document = json_encoded
+		}
+
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(document)
+	}
+}
+
+// pathOf turns the wildcards of an httprouter path into OpenAPI path
+// parameters, e.g. /api/customers/:id into /api/customers/{id}.
+func pathOf(path string) (string, []interface{}) {
+	parameters := []interface{}{}
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+
+	return strings.Join(segments, "/"), parameters
+}
+
+func operation(route router.Route, parameters []interface{}, schemas *schemaSet) map[string]interface{} {
+	endpoint := route.Endpoint
+
+	status_code := endpoint.Status
+	if status_code == 0 {
+		status_code = http.StatusOK
+	}
+
+	success := map[string]interface{}{
+		"description": http.StatusText(status_code),
+	}
+
+	if status_code != http.StatusNoContent {
+		var data map[string]interface{}
+		if endpoint.Response != nil {
+			data = schemas.of(endpoint.Response, true)
+		}
+
+		success["content"] = jsonContent(envelope(data))
+	}
+
+	operation := map[string]interface{}{
+		"responses": map[string]interface{}{
+			strconv.Itoa(status_code): success,
+			"default":                 map[string]interface{}{"$ref": "#/components/responses/Error"},
+		},
+	}
+
+	if endpoint.Summary != "" {
+		operation["summary"] = endpoint.Summary
+	}
+
+	if len(endpoint.Tags) > 0 {
+		operation["tags"] = endpoint.Tags
+	}
+
+	if len(endpoint.Permissions) > 0 {
+		permissions := append([]string{}, endpoint.Permissions...)
+		sort.Strings(permissions)
+
+		operation["description"] = "Permissions: " + strings.Join(permissions, ", ")
+	}
+
+	if len(endpoint.Security) > 0 {
+		security := make([]interface{}, 0, len(endpoint.Security))
+		for _, scheme := range endpoint.Security {
+			security = append(security, map[string]interface{}{scheme: []string{}})
+		}
+
+		operation["security"] = security
+	}
+
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if endpoint.Request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(schemas.of(endpoint.Request, false)),
+		}
+	}
+
+	return operation
+}
+
+// envelope describes the body written by package response, data is left out
+// when nil.
+func envelope(data map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{
+		"message": map[string]interface{}{"type": "string"},
+	}
+
+	if data != nil {
+		properties["data"] = data
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"required":   []string{"message"},
+		"properties": properties,
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
@@ -0,0 +1,357 @@
+package openapi
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Schemer is implemented by types whose JSON is not what their Go type
+// suggests, e.g. a date kept in a time.Time.
+type Schemer interface {
+	JSONSchema() map[string]interface{}
+}
+
+var schemer_type = reflect.TypeOf((*Schemer)(nil)).Elem()
+var time_type = reflect.TypeOf(time.Time{})
+var uuid_type = reflect.TypeOf(uuid.UUID{})
+
+// schemaSet describes Go values as JSON schemas, named structs once in
+// components and referenced everywhere else.
+//
+// Values are described either as requests or as responses. A field that is
+// required in requests but left out of responses when empty, such as the
+// password of model.User, is not required in responses. Structs with such
+// fields get a second component for requests, named with a Request suffix.
+type schemaSet struct {
+	components map[string]interface{}
+	names      map[schemaKey]string
+	// owners are the types components are named after, to tell apart types
+	// of different packages sharing a name
+	owners map[string]reflect.Type
+}
+
+type schemaKey struct {
+	t        reflect.Type
+	response bool
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{
+		components: map[string]interface{}{},
+		names:      map[schemaKey]string{},
+		owners:     map[string]reflect.Type{},
+	}
+}
+
+// of describes value, the values of a map[string]interface{} being
+// described as its properties.
+func (set *schemaSet) of(value interface{}, response bool) map[string]interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return set.typeOf(reflect.TypeOf(value), response)
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	properties := map[string]interface{}{}
+	for _, key := range keys {
+		properties[key] = set.of(object[key], response)
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func (set *schemaSet) typeOf(t reflect.Type, response bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+
+	if t.Implements(schemer_type) {
+		return reflect.Zero(t).Interface().(Schemer).JSONSchema()
+	}
+
+	switch t {
+	case time_type:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuid_type:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return set.typeOf(t.Elem(), response)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": set.typeOf(t.Elem(), response)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": set.typeOf(t.Elem(), response)}
+	case reflect.Struct:
+		if t.Name() == "" || !isExported(t.Name()) {
+			return set.object(t, response)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + set.name(t, response)}
+	}
+
+	return map[string]interface{}{}
+}
+
+// name returns the component of t, describing it the first time. Types of
+// different packages sharing a name are told apart by their package.
+func (set *schemaSet) name(t reflect.Type, response bool) string {
+	// requests and responses share the component of t unless they differ
+	if !response && !differs(t, map[reflect.Type]bool{}) {
+		response = true
+	}
+
+	key := schemaKey{t: t, response: response}
+
+	name, ok := set.names[key]
+	if ok {
+		return name
+	}
+
+	name = t.Name()
+	if owner, taken := set.owners[name]; taken && owner != t {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	set.owners[name] = t
+
+	if !response {
+		name += "Request"
+	}
+
+	set.names[key] = name
+	// claimed before describing t, so a type referencing itself ends there
+	set.components[name] = map[string]interface{}{}
+	set.components[name] = set.object(t, response)
+
+	return name
+}
+
+// differs reports whether t, or a struct it holds, has a field required in
+// requests but left out of responses when empty.
+func differs(t reflect.Type, seen map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return differs(t.Elem(), seen)
+	case reflect.Struct:
+	default:
+		return false
+	}
+
+	if seen[t] || t == time_type || t == uuid_type || t.Implements(schemer_type) {
+		return false
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		if omitEmpty(tag) && validate(map[string]interface{}{}, field.Tag.Get("validate")) {
+			return true
+		}
+
+		if differs(field.Type, seen) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func omitEmpty(tag string) bool {
+	for _, option := range strings.Split(tag, ",")[1:] {
+		if option == "omitempty" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (set *schemaSet) object(t reflect.Type, response bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	set.fields(t, properties, &required, response)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// fields describes the fields of t the way encoding/json writes them, those
+// of embedded structs without a json name included.
+func (set *schemaSet) fields(t reflect.Type, properties map[string]interface{}, required *[]string, response bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				set.fields(embedded, properties, required, response)
+
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := set.typeOf(field.Type, response)
+
+		// responses leave out empty omitempty fields whatever requests need
+		if validate(schema, field.Tag.Get("validate")) && !(response && omitEmpty(tag)) {
+			*required = append(*required, name)
+		}
+
+		properties[name] = schema
+	}
+}
+
+// validate adds the rules of a validate tag to schema and reports whether
+// they require the field. Rules after dive apply to the items of schema.
+func validate(schema map[string]interface{}, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	rules := strings.Split(tag, ",")
+	required := false
+
+	for i, rule := range rules {
+		name, param := rule, ""
+
+		equals := strings.Index(rule, "=")
+		if equals >= 0 {
+			name, param = rule[:equals], rule[equals+1:]
+		}
+
+		switch name {
+		case "required", "daterequired":
+			required = true
+		case "email":
+			schema["format"] = "email"
+		case "url":
+			schema["format"] = "uri"
+		case "alphanum":
+			schema["pattern"] = "^[a-zA-Z0-9]+$"
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "min", "max", "len":
+			bound(schema, name, param)
+		case "dive":
+			items, ok := schema["items"].(map[string]interface{})
+			if ok {
+				validate(items, strings.Join(rules[i+1:], ","))
+			}
+
+			return required
+		}
+	}
+
+	return required
+}
+
+// bound sets the min, max or len rule on the keyword matching the type of
+// schema.
+func bound(schema map[string]interface{}, rule, param string) {
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+
+	var keyword string
+
+	switch schema["type"] {
+	case "string":
+		keyword = "Length"
+	case "array":
+		keyword = "Items"
+	case "object":
+		keyword = "Properties"
+	case "integer", "number":
+		switch rule {
+		case "min":
+			schema["minimum"] = value
+		case "max":
+			schema["maximum"] = value
+		case "len":
+			schema["minimum"] = value
+			schema["maximum"] = value
+		}
+
+		return
+	default:
+		return
+	}
+
+	switch rule {
+	case "min":
+		schema["min"+keyword] = int(value)
+	case "max":
+		schema["max"+keyword] = int(value)
+	case "len":
+		schema["min"+keyword] = int(value)
+		schema["max"+keyword] = int(value)
+	}
+}
+
+func isExported(name string) bool {
+	return name[:1] == strings.ToUpper(name[:1])
+}
@@ -0,0 +1,326 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const AlgorithmBcrypt string = "bcrypt"
+const AlgorithmArgon2id string = "argon2id"
+
+// legacy_pepper_id names the pepper of hashes stored before peppers had
+// their own configuration: a bare bcrypt hash of HMAC-SHA256(JWT_SECRET_KEY).
+const legacy_pepper_id string = "legacy"
+
+var ErrUnknownPepper = errors.New("password: hash uses an unknown pepper")
+var ErrInvalidHash = errors.New("password: invalid stored hash")
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	key_len uint32
+}
+
+type config struct {
+	peppers       map[string][]byte
+	pepper_id     string
+	algorithm     string
+	bcrypt_cost   int
+	argon2_params argon2Params
+}
+
+var mutex sync.RWMutex
+var current *config
+
+// Load reads the hashing configuration from the environment.
+//
+//	PASSWORD_PEPPERS         comma separated id:secret pairs, old ids stay listed until every hash is rehashed
+//	PASSWORD_PEPPER_ID       id of the pepper new hashes use
+//	PASSWORD_ALGORITHM       bcrypt (default) or argon2id
+//	PASSWORD_BCRYPT_COST     bcrypt cost of new hashes, bcrypt.DefaultCost when empty
+//	PASSWORD_LEGACY_PEPPER   secret of hashes without a prefix, JWT_SECRET_KEY when empty
+func Load() error {
+	loaded := &config{
+		peppers:     map[string][]byte{},
+		pepper_id:   strings.TrimSpace(os.Getenv("PASSWORD_PEPPER_ID")),
+		algorithm:   strings.TrimSpace(os.Getenv("PASSWORD_ALGORITHM")),
+		bcrypt_cost: bcrypt.DefaultCost,
+		argon2_params: argon2Params{
+			memory:  64 * 1024,
+			time:    3,
+			threads: 2,
+			key_len: 32,
+		},
+	}
+
+	for _, entry := range strings.Split(os.Getenv("PASSWORD_PEPPERS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, ":", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" || strings.Contains(fields[0], "$") || fields[0] == legacy_pepper_id {
+			return fmt.Errorf("password: invalid PASSWORD_PEPPERS entry %q", fields[0])
+		}
+
+		loaded.peppers[fields[0]] = []byte(fields[1])
+	}
+
+	legacy_pepper := os.Getenv("PASSWORD_LEGACY_PEPPER")
+	if legacy_pepper == "" {
+		legacy_pepper = os.Getenv("JWT_SECRET_KEY")
+	}
+
+	if legacy_pepper != "" {
+		loaded.peppers[legacy_pepper_id] = []byte(legacy_pepper)
+	}
+
+	if loaded.pepper_id == "" || loaded.pepper_id == legacy_pepper_id {
+		return errors.New("password: PASSWORD_PEPPER_ID must name one of PASSWORD_PEPPERS")
+	}
+
+	_, ok := loaded.peppers[loaded.pepper_id]
+	if !ok {
+		return fmt.Errorf("password: pepper %s is not in PASSWORD_PEPPERS", loaded.pepper_id)
+	}
+
+	switch loaded.algorithm {
+	case "":
+		loaded.algorithm = AlgorithmBcrypt
+	case AlgorithmBcrypt, AlgorithmArgon2id:
+	default:
+		return fmt.Errorf("password: unsupported PASSWORD_ALGORITHM %s", loaded.algorithm)
+	}
+
+	cost := os.Getenv("PASSWORD_BCRYPT_COST")
+	if cost != "" {
+		value, err := strconv.Atoi(cost)
+		if err != nil || value < bcrypt.MinCost || value > bcrypt.MaxCost {
+			return fmt.Errorf("password: invalid PASSWORD_BCRYPT_COST %s", cost)
+		}
+
+		loaded.bcrypt_cost = value
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current = loaded
+
+	return nil
+}
+
+func getConfig() (*config, error) {
+	mutex.RLock()
+	loaded := current
+	mutex.RUnlock()
+
+	if loaded != nil {
+		return loaded, nil
+	}
+
+	err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return current, nil
+}
+
+func pepper(secret []byte, plain string) []byte {
+	hmac_sha256 := hmac.New(sha256.New, secret)
+	hmac_sha256.Write([]byte(plain))
+
+	return hmac_sha256.Sum(nil)
+}
+
+// Hash returns plain hashed with the configured algorithm and pepper, in
+// the form $<algorithm>$p=<pepper id>$<algorithm specific hash>.
+func Hash(plain string) (string, error) {
+	loaded, err := getConfig()
+	if err != nil {
+		return "", err
+	}
+
+	peppered := pepper(loaded.peppers[loaded.pepper_id], plain)
+
+	if loaded.algorithm == AlgorithmArgon2id {
+		salt := make([]byte, 16)
+
+		_, err = rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+
+		params := loaded.argon2_params
+		key := argon2.IDKey(peppered, salt, params.time, params.memory, params.threads, params.key_len)
+
+		return fmt.Sprintf("$%s$p=%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			AlgorithmArgon2id, loaded.pepper_id, argon2.Version, params.memory, params.time, params.threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword(peppered, loaded.bcrypt_cost)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$%s$p=%s$%s", AlgorithmBcrypt, loaded.pepper_id, hashed), nil
+}
+
+type parsedHash struct {
+	algorithm     string
+	pepper_id     string
+	bcrypt_hash   []byte
+	argon2_params argon2Params
+	salt          []byte
+	key           []byte
+}
+
+func parse(stored string) (parsedHash, error) {
+	var parsed parsedHash
+
+	// bare bcrypt hashes predate the prefix
+	if strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$") {
+		parsed.algorithm = AlgorithmBcrypt
+		parsed.pepper_id = legacy_pepper_id
+		parsed.bcrypt_hash = []byte(stored)
+
+		return parsed, nil
+	}
+
+	fields := strings.SplitN(stored, "$", 4)
+	if len(fields) != 4 || fields[0] != "" || !strings.HasPrefix(fields[2], "p=") {
+		return parsed, ErrInvalidHash
+	}
+
+	parsed.algorithm = fields[1]
+	parsed.pepper_id = strings.TrimPrefix(fields[2], "p=")
+
+	switch parsed.algorithm {
+	case AlgorithmBcrypt:
+		parsed.bcrypt_hash = []byte(fields[3])
+
+		return parsed, nil
+	case AlgorithmArgon2id:
+		var version int
+		var params argon2Params
+
+		argon2_fields := strings.Split(fields[3], "$")
+		if len(argon2_fields) != 4 {
+			return parsed, ErrInvalidHash
+		}
+
+		_, err := fmt.Sscanf(argon2_fields[0], "v=%d", &version)
+		if err != nil || version != argon2.Version {
+			return parsed, ErrInvalidHash
+		}
+
+		_, err = fmt.Sscanf(argon2_fields[1], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+		if err != nil {
+			return parsed, ErrInvalidHash
+		}
+
+		parsed.salt, err = base64.RawStdEncoding.DecodeString(argon2_fields[2])
+		if err != nil {
+			return parsed, ErrInvalidHash
+		}
+
+		parsed.key, err = base64.RawStdEncoding.DecodeString(argon2_fields[3])
+		if err != nil {
+			return parsed, ErrInvalidHash
+		}
+
+		params.key_len = uint32(len(parsed.key))
+		parsed.argon2_params = params
+
+		return parsed, nil
+	}
+
+	return parsed, ErrInvalidHash
+}
+
+// Verify checks plain against a stored hash. needs_rehash is true when the
+// password matched but the hash uses an older pepper, algorithm or cost and
+// should be replaced with Hash(plain).
+func Verify(plain, stored string) (ok bool, needs_rehash bool, err error) {
+	loaded, err := getConfig()
+	if err != nil {
+		return false, false, err
+	}
+
+	parsed, err := parse(stored)
+	if err != nil {
+		return false, false, err
+	}
+
+	secret, known := loaded.peppers[parsed.pepper_id]
+	if !known {
+		return false, false, ErrUnknownPepper
+	}
+
+	peppered := pepper(secret, plain)
+
+	switch parsed.algorithm {
+	case AlgorithmBcrypt:
+		err = bcrypt.CompareHashAndPassword(parsed.bcrypt_hash, peppered)
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost(parsed.bcrypt_hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		needs_rehash = loaded.algorithm != AlgorithmBcrypt || cost != loaded.bcrypt_cost
+	case AlgorithmArgon2id:
+		params := parsed.argon2_params
+		key := argon2.IDKey(peppered, parsed.salt, params.time, params.memory, params.threads, params.key_len)
+		if subtle.ConstantTimeCompare(key, parsed.key) != 1 {
+			return false, false, nil
+		}
+
+		needs_rehash = loaded.algorithm != AlgorithmArgon2id || params != loaded.argon2_params
+	}
+
+	needs_rehash = needs_rehash || parsed.pepper_id != loaded.pepper_id
+
+	return true, needs_rehash, nil
+}
+
+var dummy_once sync.Once
+var dummy_hash string
+
+// VerifyDummy spends about as long as Verify on a real hash. Sign-in calls
+// it for unknown emails so they cannot be told apart by timing.
+func VerifyDummy(plain string) {
+	dummy_once.Do(func() {
+		dummy_hash, _ = Hash("dummy password")
+	})
+
+	if dummy_hash != "" {
+		Verify(plain, dummy_hash)
+	}
+}
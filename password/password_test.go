@@ -0,0 +1,208 @@
+package password
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var config_env = []string{"PASSWORD_PEPPERS", "PASSWORD_PEPPER_ID", "PASSWORD_ALGORITHM", "PASSWORD_BCRYPT_COST", "PASSWORD_LEGACY_PEPPER", "JWT_SECRET_KEY"}
+
+// setEnv sets the configuration to env, leaving the variables it does not
+// name empty.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for _, key := range config_env {
+		t.Setenv(key, env[key])
+	}
+}
+
+func load(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	setEnv(t, env)
+	require.NoError(t, Load())
+}
+
+func hash(t *testing.T, plain string) string {
+	t.Helper()
+
+	stored, err := Hash(plain)
+	require.NoError(t, err)
+
+	return stored
+}
+
+func TestLoad(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		env  map[string]string
+	}{
+		{"missing pepper id", map[string]string{"PASSWORD_PEPPERS": "1:pepper"}},
+		{"legacy pepper id", map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "legacy", "JWT_SECRET_KEY": "secret"}},
+		{"pepper id not listed", map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "2"}},
+		{"legacy in peppers", map[string]string{"PASSWORD_PEPPERS": "1:pepper,legacy:secret", "PASSWORD_PEPPER_ID": "1"}},
+		{"pepper without secret", map[string]string{"PASSWORD_PEPPERS": "1:pepper,2:", "PASSWORD_PEPPER_ID": "1"}},
+		{"pepper id with $", map[string]string{"PASSWORD_PEPPERS": "1$:pepper", "PASSWORD_PEPPER_ID": "1$"}},
+		{"unknown algorithm", map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_ALGORITHM": "md5"}},
+		{"cost too low", map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "3"}},
+		{"cost not a number", map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "ten"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			assert.Error(t, Load())
+		})
+	}
+
+	load(t, map[string]string{"PASSWORD_PEPPERS": " 1:pepper , 2:other ", "PASSWORD_PEPPER_ID": "2", "PASSWORD_BCRYPT_COST": "4"})
+	assert.True(t, strings.HasPrefix(hash(t, "rahasia"), "$bcrypt$p=2$$2a$04$"))
+}
+
+func TestLegacyBcrypt(t *testing.T) {
+	legacy_hash, err := bcrypt.GenerateFromPassword(pepper([]byte("jwt secret"), "rahasia"), 4)
+	require.NoError(t, err)
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		t.Run(prefix, func(t *testing.T) {
+			stored := prefix + strings.TrimPrefix(string(legacy_hash), "$2a$")
+
+			parsed, err := parse(stored)
+			require.NoError(t, err)
+			assert.Equal(t, AlgorithmBcrypt, parsed.algorithm)
+			assert.Equal(t, legacy_pepper_id, parsed.pepper_id)
+
+			// peppered with JWT_SECRET_KEY unless PASSWORD_LEGACY_PEPPER says otherwise
+			load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "4", "JWT_SECRET_KEY": "jwt secret"})
+
+			ok, needs_rehash, err := Verify("rahasia", stored)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, needs_rehash)
+
+			ok, _, err = Verify("salah", stored)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_LEGACY_PEPPER": "jwt secret", "JWT_SECRET_KEY": "rotated"})
+
+			ok, _, err = Verify("rahasia", stored)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1"})
+
+			_, _, err = Verify("rahasia", stored)
+			assert.ErrorIs(t, err, ErrUnknownPepper)
+		})
+	}
+}
+
+func TestUnknownPepper(t *testing.T) {
+	load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "4"})
+	stored := hash(t, "rahasia")
+
+	// pepper 1 was dropped before every hash was rehashed
+	load(t, map[string]string{"PASSWORD_PEPPERS": "2:other", "PASSWORD_PEPPER_ID": "2", "PASSWORD_BCRYPT_COST": "4"})
+
+	ok, needs_rehash, err := Verify("rahasia", stored)
+	assert.ErrorIs(t, err, ErrUnknownPepper)
+	assert.False(t, ok)
+	assert.False(t, needs_rehash)
+}
+
+func TestInvalidHash(t *testing.T) {
+	load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1"})
+
+	for _, stored := range []string{
+		"",
+		"rahasia",
+		"$bcrypt$1$hash",
+		"$md5$p=1$hash",
+		"$argon2id$p=1$v=18$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$p=1$v=19$m=65536,t=3$c2FsdA$a2V5",
+		"$argon2id$p=1$v=19$m=65536,t=3,p=2$!$a2V5",
+		"$argon2id$p=1$v=19$m=65536,t=3,p=2$c2FsdA",
+	} {
+		t.Run(stored, func(t *testing.T) {
+			ok, _, err := Verify("rahasia", stored)
+			assert.ErrorIs(t, err, ErrInvalidHash)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcrypt_env := map[string]string{"PASSWORD_PEPPERS": "1:pepper,2:other", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "4"}
+
+	for _, test := range []struct {
+		name string
+		env  map[string]string
+	}{
+		{"pepper", map[string]string{"PASSWORD_PEPPERS": "1:pepper,2:other", "PASSWORD_PEPPER_ID": "2", "PASSWORD_BCRYPT_COST": "4"}},
+		{"algorithm", map[string]string{"PASSWORD_PEPPERS": "1:pepper,2:other", "PASSWORD_PEPPER_ID": "1", "PASSWORD_ALGORITHM": AlgorithmArgon2id}},
+		{"cost", map[string]string{"PASSWORD_PEPPERS": "1:pepper,2:other", "PASSWORD_PEPPER_ID": "1", "PASSWORD_BCRYPT_COST": "5"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			load(t, bcrypt_env)
+			stored := hash(t, "rahasia")
+
+			ok, needs_rehash, err := Verify("rahasia", stored)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.False(t, needs_rehash)
+
+			load(t, test.env)
+
+			ok, needs_rehash, err = Verify("rahasia", stored)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, needs_rehash)
+
+			// a wrong password is never worth rehashing
+			ok, needs_rehash, err = Verify("salah", stored)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.False(t, needs_rehash)
+
+			ok, needs_rehash, err = Verify("rahasia", hash(t, "rahasia"))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.False(t, needs_rehash)
+		})
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	load(t, map[string]string{"PASSWORD_PEPPERS": "1:pepper", "PASSWORD_PEPPER_ID": "1", "PASSWORD_ALGORITHM": AlgorithmArgon2id})
+
+	stored := hash(t, "rahasia")
+	assert.True(t, strings.HasPrefix(stored, fmt.Sprintf("$argon2id$p=1$v=%d$m=65536,t=3,p=2$", argon2.Version)), stored)
+	assert.NotEqual(t, stored, hash(t, "rahasia"), "every hash has a salt of its own")
+
+	ok, needs_rehash, err := Verify("rahasia", stored)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needs_rehash)
+
+	ok, _, err = Verify("salah", stored)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// hashed with weaker parameters than the configured ones
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey(pepper([]byte("pepper"), "rahasia"), salt, 1, 8*1024, 1, 32)
+	weak := fmt.Sprintf("$argon2id$p=1$v=%d$m=%d,t=1,p=1$%s$%s", argon2.Version, 8*1024,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	ok, needs_rehash, err = Verify("rahasia", weak)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needs_rehash)
+}
@@ -1,27 +1,77 @@
 package router
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/mmiftahrzki/go-rest-api/middleware"
+	"github.com/mmiftahrzki/go-rest-api/middleware/rbac"
 	"github.com/mmiftahrzki/go-rest-api/response"
 )
 
 type Endpoint struct {
 	Middlewares []middleware.Middleware
+	// Permissions are enforced by the rbac middleware after Middlewares, so
+	// an endpoint declaring them must also be behind the auth middleware.
+	Permissions []string
 	Method      string
 	Path        string
+
+	// the fields below only document the endpoint, see package openapi
+
+	Summary string
+	Tags    []string
+	// Security names the security schemes a request can use, any one of
+	// them is enough.
+	Security []string
+	// Request is a value of the type the JSON body is decoded into.
+	Request interface{}
+	// Response is a value of what a successful response holds in data, e.g.
+	// map[string]interface{}{"customer": model.Customer{}}.
+	Response interface{}
+	// Status is the status code of a successful response, 200 when zero.
+	Status int
+}
+
+// Route is an endpoint as it was handled, with the names of the functions
+// serving it.
+type Route struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Middlewares run in this order, those added with Use and by groups
+	// included.
+	Middlewares []string `json:"middlewares"`
+	Permissions []string `json:"permissions,omitempty"`
+	Handler     string   `json:"handler"`
+	Endpoint    Endpoint `json:"-"`
 }
 
 type Router struct {
-	endpoints  map[string]Endpoint
-	httprouter *httprouter.Router
+	endpoints          map[string]Route
+	httprouter         *httprouter.Router
+	middlewares        []middleware.Middleware
+	not_found          http.Handler
+	method_not_allowed http.Handler
+	options            http.Handler
+	// paths whose OPTIONS requests are handled by a group's preflight
+	// middlewares rather than by the options handler alone
+	preflights map[string]bool
+	errs       []error
 }
 
 func New() *Router {
-	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	router := &Router{
+		httprouter: httprouter.New(),
+		endpoints:  map[string]Route{},
+		preflights: map[string]bool{},
+	}
+
+	router.not_found = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		response := response.New()
 		response.Message = "sumber daya yang Anda cari tidak ditemukan"
 
@@ -29,24 +79,394 @@ func New() *Router {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write(response.ToJson())
 	})
-	router.MethodNotAllowed = router.NotFound
 
-	return &Router{
-		httprouter: router,
-		endpoints:  map[string]Endpoint{},
-	}
+	// httprouter sets the Allow header before calling these two
+	router.method_not_allowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := response.New()
+		response.Message = fmt.Sprintf("metode %s tidak diizinkan untuk sumber daya ini", r.Method)
+		response.Data["allow"] = allowed(w)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(response.ToJson())
+	})
+	router.options = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := response.New()
+		response.Message = "berhasil mendapatkan daftar metode yang diizinkan"
+		response.Data["allow"] = allowed(w)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response.ToJson())
+	})
+
+	router.fallbacks()
+
+	return router
+}
+
+// allowed returns the methods of the Allow header set on writer.
+func allowed(writer http.ResponseWriter) []string {
+	return strings.Split(writer.Header().Get("Allow"), ", ")
 }
 
 func (router *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	router.httprouter.ServeHTTP(writer, request)
 }
 
+// Use adds middlewares that run before those of every endpoint, and around
+// the NotFound, MethodNotAllowed and OPTIONS handlers. They only reach
+// endpoints handled after them, so Use panics once an endpoint has been
+// handled.
+func (router *Router) Use(middlewares ...middleware.Middleware) {
+	if len(router.endpoints) > 0 {
+		panic("router: Use must be called before any endpoint is handled")
+	}
+
+	router.middlewares = append(router.middlewares, middlewares...)
+	router.fallbacks()
+}
+
+// fallbacks sets the handlers of the requests no endpoint serves, wrapped in
+// the middlewares added with Use.
+func (router *Router) fallbacks() {
+	router.httprouter.NotFound = router.wrap(router.not_found)
+	router.httprouter.MethodNotAllowed = router.wrap(router.method_not_allowed)
+	router.httprouter.GlobalOPTIONS = router.wrap(router.options)
+}
+
+func (router *Router) wrap(handler http.Handler) http.Handler {
+	handle := chain(router.middlewares, func(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		handler.ServeHTTP(writer, request)
+	})
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		handle(writer, request, nil)
+	})
+}
+
+// Group returns a group of endpoints sharing prefix and middlewares.
+func (router *Router) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return &Group{
+		router:      router,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// Handle serves endpoint with handle. An endpoint that is already handled,
+// or that httprouter could not tell apart from one, is not handled and its
+// error is kept for Err. A GET endpoint answers HEAD as well, net/http
+// leaving the body out.
 func (router *Router) Handle(endpoint Endpoint, handle httprouter.Handle) {
+	middlewares := make([]middleware.Middleware, 0, len(router.middlewares)+len(endpoint.Middlewares))
+	middlewares = append(middlewares, router.middlewares...)
+	middlewares = append(middlewares, endpoint.Middlewares...)
+
+	route := Route{
+		Method:      endpoint.Method,
+		Path:        endpoint.Path,
+		Middlewares: make([]string, 0, len(middlewares)),
+		Permissions: endpoint.Permissions,
+		Handler:     funcName(handle),
+		Endpoint:    endpoint,
+	}
+
+	for _, middleware := range middlewares {
+		route.Middlewares = append(route.Middlewares, funcName(middleware))
+	}
+
+	err := router.check(route)
+	if err != nil {
+		router.errs = append(router.errs, err)
+
+		return
+	}
+
 	var handlers httprouter.Handle = handle
 
-	for i := len(endpoint.Middlewares) - 1; i >= 0; i-- {
-		handlers = endpoint.Middlewares[i](handlers)
+	if len(endpoint.Permissions) > 0 {
+		handlers = rbac.New(endpoint.Permissions...)(handlers)
+	}
+
+	err = router.handle(endpoint.Method, endpoint.Path, chain(middlewares, handlers))
+	if err != nil {
+		router.errs = append(router.errs, err)
+
+		return
+	}
+
+	router.endpoints[endpoint.Method+" "+endpoint.Path] = route
+
+	_, head_handled := router.endpoints[http.MethodHead+" "+endpoint.Path]
+	if endpoint.Method == http.MethodGet && !head_handled {
+		err = router.handle(http.MethodHead, endpoint.Path, chain(middlewares, handlers))
+		if err != nil {
+			router.errs = append(router.errs, err)
+		}
+	}
+}
+
+// handle turns the panics of httprouter check misses, such as a path not
+// starting with a slash, into an error.
+func (router *Router) handle(method, path string, handle httprouter.Handle) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			err = fmt.Errorf("router: %s %s: %v", method, path, recovered)
+		}
+	}()
+
+	router.httprouter.Handle(method, path, handle)
+
+	return nil
+}
+
+// preflight answers the OPTIONS requests of path with the options handler
+// behind middlewares, once per path. The Allow header is set first, as
+// httprouter does for the OPTIONS requests it answers itself.
+func (router *Router) preflight(path string, middlewares []middleware.Middleware) {
+	if router.preflights[path] {
+		return
+	}
+
+	router.preflights[path] = true
+
+	all := make([]middleware.Middleware, 0, len(router.middlewares)+len(middlewares))
+	all = append(all, router.middlewares...)
+	all = append(all, middlewares...)
+
+	handle := chain(all, func(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		router.options.ServeHTTP(writer, request)
+	})
+
+	err := router.handle(http.MethodOptions, path, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		writer.Header().Set("Allow", router.allow(request.URL.Path))
+		handle(writer, request, params)
+	})
+	if err != nil {
+		router.errs = append(router.errs, err)
+	}
+}
+
+// allow lists the methods handled on path like httprouter does in the Allow
+// header, OPTIONS included.
+func (router *Router) allow(path string) string {
+	methods := map[string]bool{http.MethodOptions: true}
+	for _, route := range router.endpoints {
+		handle, _, _ := router.httprouter.Lookup(route.Method, path)
+		if handle == nil {
+			continue
+		}
+
+		methods[route.Method] = true
+		if route.Method == http.MethodGet {
+			methods[http.MethodHead] = true
+		}
+	}
+
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return strings.Join(allowed, ", ")
+}
+
+// check returns an error when route is already handled or would make
+// httprouter panic for conflicting with a handled one.
+func (router *Router) check(route Route) error {
+	key := route.Method + " " + route.Path
+
+	existing, ok := router.endpoints[key]
+	if ok {
+		return fmt.Errorf("router: %s is handled twice, by %s and %s", key, existing.Handler, route.Handler)
+	}
+
+	_, ok = router.endpoints[http.MethodGet+" "+route.Path]
+	if ok && route.Method == http.MethodHead {
+		return fmt.Errorf("router: %s is already answered by GET %s", key, route.Path)
+	}
+
+	for _, existing := range router.endpoints {
+		if existing.Method == route.Method && conflicts(existing.Path, route.Path) {
+			return fmt.Errorf("router: %s conflicts with %s %s handled by %s, a wildcard cannot share its position with another wildcard or a static segment", key, existing.Method, existing.Path, existing.Handler)
+		}
+	}
+
+	return nil
+}
+
+// conflicts reports whether httprouter refuses to tell path from other: at
+// the first segment they differ in, one of them is a wildcard.
+func conflicts(path, other string) bool {
+	segments := strings.Split(path, "/")
+	other_segments := strings.Split(other, "/")
+
+	for i := 0; i < len(segments) && i < len(other_segments); i++ {
+		if segments[i] == other_segments[i] {
+			continue
+		}
+
+		return isWildcard(segments[i]) || isWildcard(other_segments[i])
+	}
+
+	return false
+}
+
+func isWildcard(segment string) bool {
+	return strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*")
+}
+
+// Err returns the errors of the endpoints Handle refused, nil when it
+// handled them all. Check it once every endpoint is handled.
+func (router *Router) Err() error {
+	if len(router.errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(router.errs))
+	for _, err := range router.errs {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Errorf("%s", strings.Join(messages, "\n"))
+}
+
+// Routes returns the handled routes ordered by path, then method.
+func (router *Router) Routes() []Route {
+	routes := make([]Route, 0, len(router.endpoints))
+	for _, route := range router.endpoints {
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// ServeRoutes lists Routes, it is meant to be handled behind the auth and
+// an admin permission.
+func (router *Router) ServeRoutes(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	response := response.New()
+	response.Message = "berhasil mendapatkan daftar route"
+	response.Data["routes"] = router.Routes()
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(response.ToJson())
+}
+
+// funcName names fn after the function it was declared as, without the
+// module path, the -fm suffix of method values and the .funcN suffixes of
+// closures, e.g. controller.(*customer).ReadAll or auth.New.
+func funcName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+
+	slash := strings.LastIndex(name, "/")
+	if slash >= 0 {
+		name = name[slash+1:]
+	}
+
+	for {
+		dot := strings.LastIndex(name, ".func")
+		if dot < 0 || strings.Trim(name[dot+len(".func"):], "0123456789.") != "" {
+			return name
+		}
+
+		name = name[:dot]
+	}
+}
+
+// chain wraps handle in middlewares, the first of them running first.
+func chain(middlewares []middleware.Middleware, handle httprouter.Handle) httprouter.Handle {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handle = middlewares[i](handle)
+	}
+
+	return handle
+}
+
+// Group handles endpoints under a shared path prefix, running the group's
+// middlewares before the endpoint's own.
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []middleware.Middleware
+	preflight   []middleware.Middleware
+	tags        []string
+	security    []string
+}
+
+// Group returns a group nested in group, its prefix and middlewares come
+// after those of group. It is described like group until Describe says
+// otherwise.
+func (group *Group) Group(prefix string, middlewares ...middleware.Middleware) *Group {
+	return &Group{
+		router:      group.router,
+		prefix:      group.prefix + prefix,
+		middlewares: group.with(middlewares),
+		preflight:   group.preflight,
+		tags:        group.tags,
+		security:    group.security,
 	}
+}
+
+// Preflight puts middlewares, such as package cors, before the group's
+// other middlewares, and has them answer the OPTIONS requests of the paths
+// of the group's endpoints as well. Those requests carry no credentials, so
+// the other middlewares, the auth one among them, are left out of them.
+// Like Describe, it must be called before the group handles any endpoint.
+func (group *Group) Preflight(middlewares ...middleware.Middleware) *Group {
+	group.preflight = append(append([]middleware.Middleware{}, middlewares...), group.preflight...)
+	group.middlewares = append(append([]middleware.Middleware{}, middlewares...), group.middlewares...)
+
+	return group
+}
+
+// Describe sets the Tags and Security of the group's endpoints that have
+// none of their own.
+func (group *Group) Describe(tags []string, security ...string) *Group {
+	group.tags = tags
+	group.security = security
+
+	return group
+}
+
+// Handle handles endpoint with its Path relative to the group's prefix, an
+// empty Path being the prefix itself.
+func (group *Group) Handle(endpoint Endpoint, handle httprouter.Handle) {
+	endpoint.Path = group.prefix + endpoint.Path
+	endpoint.Middlewares = group.with(endpoint.Middlewares)
+
+	if endpoint.Tags == nil {
+		endpoint.Tags = group.tags
+	}
+
+	if endpoint.Security == nil {
+		endpoint.Security = group.security
+	}
+
+	group.router.Handle(endpoint, handle)
+
+	if len(group.preflight) > 0 {
+		group.router.preflight(endpoint.Path, group.preflight)
+	}
+}
+
+// with returns the group's middlewares followed by middlewares, in a new
+// slice so sibling groups never share a backing array.
+func (group *Group) with(middlewares []middleware.Middleware) []middleware.Middleware {
+	combined := make([]middleware.Middleware, 0, len(group.middlewares)+len(middlewares))
+	combined = append(combined, group.middlewares...)
 
-	router.httprouter.Handle(endpoint.Method, endpoint.Path, handlers)
+	return append(combined, middlewares...)
 }
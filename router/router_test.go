@@ -0,0 +1,308 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mmiftahrzki/go-rest-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps the names of the middlewares and handlers in the order
+// they ran.
+type recorder struct {
+	calls []string
+}
+
+func (recorder *recorder) middleware(name string) middleware.Middleware {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+			recorder.calls = append(recorder.calls, name)
+			next(writer, request, params)
+		}
+	}
+}
+
+func (recorder *recorder) handle(name string) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		recorder.calls = append(recorder.calls, name)
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+func serve(router *Router, method, path string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	response_recorder := httptest.NewRecorder()
+	router.ServeHTTP(response_recorder, request)
+
+	return response_recorder
+}
+
+func TestGroupMiddlewareOrder(t *testing.T) {
+	recorder := &recorder{}
+
+	router := New()
+	router.Use(recorder.middleware("use"))
+
+	api := router.Group("/api", recorder.middleware("api"))
+	v1 := api.Group("/v1", recorder.middleware("v1"))
+	v1.Handle(Endpoint{Method: http.MethodGet, Path: "/items", Middlewares: []middleware.Middleware{recorder.middleware("endpoint")}}, recorder.handle("items"))
+
+	// a sibling group must not see the middlewares of v1
+	v2 := api.Group("/v2", recorder.middleware("v2"))
+	v2.Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, recorder.handle("items v2"))
+
+	assert.NoError(t, router.Err())
+
+	for _, test := range []struct {
+		path  string
+		calls []string
+	}{
+		{"/api/v1/items", []string{"use", "api", "v1", "endpoint", "items"}},
+		{"/api/v2/items", []string{"use", "api", "v2", "items v2"}},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			recorder.calls = nil
+
+			response_recorder := serve(router, http.MethodGet, test.path)
+			assert.Equal(t, http.StatusOK, response_recorder.Code)
+			assert.Equal(t, test.calls, recorder.calls)
+		})
+	}
+}
+
+func TestUseWrapsFallbacks(t *testing.T) {
+	recorder := &recorder{}
+
+	router := New()
+	router.Use(recorder.middleware("first"), recorder.middleware("second"))
+	router.Group("/api", recorder.middleware("api")).Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, recorder.handle("items"))
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"not found", http.MethodGet, "/api/nothing", http.StatusNotFound},
+		{"method not allowed", http.MethodPost, "/api/items", http.StatusMethodNotAllowed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder.calls = nil
+
+			response_recorder := serve(router, test.method, test.path)
+			assert.Equal(t, test.status, response_recorder.Code)
+
+			// the group's middlewares are not the fallbacks'
+			assert.Equal(t, []string{"first", "second"}, recorder.calls)
+		})
+	}
+}
+
+func TestUseAfterHandle(t *testing.T) {
+	router := New()
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, (&recorder{}).handle("items"))
+
+	assert.Panics(t, func() { router.Use((&recorder{}).middleware("late")) })
+}
+
+func listItems(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+func readItem(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+func createItem(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func passThrough(next httprouter.Handle) httprouter.Handle {
+	return next
+}
+
+func TestErr(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		endpoint Endpoint
+		err      string
+	}{
+		{"duplicate", Endpoint{Method: http.MethodGet, Path: "/items"}, "router: GET /items is handled twice, by router.listItems and router.readItem"},
+		{"wildcard after static", Endpoint{Method: http.MethodGet, Path: "/items/:id"}, "router: GET /items/:id conflicts with GET /items/new handled by router.listItems"},
+		{"catch-all after static", Endpoint{Method: http.MethodGet, Path: "/items/*path"}, "router: GET /items/*path conflicts with GET /items/new handled by router.listItems"},
+		{"HEAD of a GET", Endpoint{Method: http.MethodHead, Path: "/items"}, "router: HEAD /items is already answered by GET /items"},
+		// httprouter panics on these, check does not look for them
+		{"no leading slash", Endpoint{Method: http.MethodPost, Path: "items"}, "router: POST items: path must begin with '/' in path 'items'"},
+		{"unnamed wildcard", Endpoint{Method: http.MethodPost, Path: "/items/:"}, "router: POST /items/:: wildcards must be named with a non-empty name in path '/items/:'"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			router := New()
+			router.Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, listItems)
+			router.Handle(Endpoint{Method: http.MethodGet, Path: "/items/new"}, listItems)
+			assert.NoError(t, router.Err())
+
+			assert.NotPanics(t, func() { router.Handle(test.endpoint, readItem) })
+
+			err := router.Err()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+
+			// the refused endpoint is neither listed nor served
+			assert.Len(t, router.Routes(), 2)
+
+			response_recorder := serve(router, http.MethodGet, "/items")
+			assert.Equal(t, http.StatusOK, response_recorder.Code)
+		})
+	}
+}
+
+func TestErrKeepsEveryError(t *testing.T) {
+	router := New()
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items/:id"}, readItem)
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items/:id"}, readItem)
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items/:item_id"}, readItem)
+
+	err := router.Err()
+	if assert.Error(t, err) {
+		assert.Equal(t, "router: GET /items/:id is handled twice, by router.readItem and router.readItem\n"+
+			"router: GET /items/:item_id conflicts with GET /items/:id handled by router.readItem, a wildcard cannot share its position with another wildcard or a static segment", err.Error())
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	router := New()
+	router.Use(passThrough)
+
+	items := router.Group("/items", passThrough)
+	items.Handle(Endpoint{Method: http.MethodPost, Path: "", Permissions: []string{"item:create"}}, createItem)
+	items.Handle(Endpoint{Method: http.MethodGet, Path: "/:id"}, readItem)
+	items.Handle(Endpoint{Method: http.MethodGet, Path: ""}, listItems)
+	items.Handle(Endpoint{Method: http.MethodGet, Path: ""}, readItem)
+
+	assert.Error(t, router.Err())
+
+	routes := router.Routes()
+	for i := range routes {
+		routes[i].Endpoint = Endpoint{}
+	}
+
+	// HEAD is answered for GET endpoints but is not a route of its own
+	assert.Equal(t, []Route{
+		{Method: http.MethodGet, Path: "/items", Middlewares: []string{"router.passThrough", "router.passThrough"}, Handler: "router.listItems"},
+		{Method: http.MethodPost, Path: "/items", Middlewares: []string{"router.passThrough", "router.passThrough"}, Permissions: []string{"item:create"}, Handler: "router.createItem"},
+		{Method: http.MethodGet, Path: "/items/:id", Middlewares: []string{"router.passThrough", "router.passThrough"}, Handler: "router.readItem"},
+	}, routes)
+}
+
+func writeItem(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte(`{"message":"item"}`))
+}
+
+func newItemRouter() *Router {
+	router := New()
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, writeItem)
+	router.Handle(Endpoint{Method: http.MethodPost, Path: "/items"}, createItem)
+	router.Handle(Endpoint{Method: http.MethodDelete, Path: "/items/:id"}, readItem)
+
+	return router
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	router := newItemRouter()
+
+	for _, test := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodPut, "/items", "GET, HEAD, OPTIONS, POST"},
+		{http.MethodGet, "/items/1", "DELETE, OPTIONS"},
+	} {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			response_recorder := serve(router, test.method, test.path)
+			assert.Equal(t, http.StatusMethodNotAllowed, response_recorder.Code)
+			assert.Equal(t, test.allow, response_recorder.Header().Get("Allow"))
+			assert.Equal(t, "application/json; charset=utf-8", response_recorder.Header().Get("Content-Type"))
+
+			body := struct {
+				Message string
+				Data    struct{ Allow []string }
+			}{}
+			require.NoError(t, json.Unmarshal(response_recorder.Body.Bytes(), &body))
+			assert.Equal(t, "metode "+test.method+" tidak diizinkan untuk sumber daya ini", body.Message)
+			assert.Equal(t, test.allow, strings.Join(body.Data.Allow, ", "))
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	router := newItemRouter()
+
+	for _, test := range []struct {
+		path  string
+		allow string
+	}{
+		{"/items", "GET, HEAD, OPTIONS, POST"},
+		{"/items/1", "DELETE, OPTIONS"},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			response_recorder := serve(router, http.MethodOptions, test.path)
+			assert.Equal(t, http.StatusOK, response_recorder.Code)
+			assert.Equal(t, test.allow, response_recorder.Header().Get("Allow"))
+
+			body := struct {
+				Data struct{ Allow []string }
+			}{}
+			require.NoError(t, json.Unmarshal(response_recorder.Body.Bytes(), &body))
+			assert.Equal(t, test.allow, strings.Join(body.Data.Allow, ", "))
+		})
+	}
+
+	response_recorder := serve(router, http.MethodOptions, "/nothing")
+	assert.Equal(t, http.StatusNotFound, response_recorder.Code)
+}
+
+// TestHead goes through a server, net/http is what leaves the body of a
+// HEAD response out.
+func TestHead(t *testing.T) {
+	server := httptest.NewServer(newItemRouter())
+	t.Cleanup(server.Close)
+
+	response, err := http.Head(server.URL + "/items")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	assert.Empty(t, body)
+
+	// only GET endpoints answer HEAD
+	response, err = http.Head(server.URL + "/items/1")
+	require.NoError(t, err)
+	response.Body.Close()
+
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+func TestHeadHandledFirst(t *testing.T) {
+	router := New()
+	router.Handle(Endpoint{Method: http.MethodHead, Path: "/items"}, createItem)
+	router.Handle(Endpoint{Method: http.MethodGet, Path: "/items"}, writeItem)
+	assert.NoError(t, router.Err())
+
+	response_recorder := serve(router, http.MethodHead, "/items")
+	assert.Equal(t, http.StatusCreated, response_recorder.Code)
+}
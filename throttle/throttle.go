@@ -0,0 +1,219 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy describes how failures against one key are punished. The first
+// FreeAttempts failures cost nothing, every further failure doubles the wait
+// starting at BaseDelay up to MaxDelay, and LockoutAfter failures lock the key
+// for LockoutDuration. Failures are forgotten after Window without any.
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// IAttemptStore counts the attempts in flight along with the failures, so a
+// burst of parallel attempts cannot all pass Check before the first of them
+// fails.
+type IAttemptStore interface {
+	// Check returns how long key must wait before its next attempt. When it
+	// returns 0 the attempt is reserved until Fail or Release ends it, and
+	// counts as failed for the attempts checked meanwhile.
+	Check(key string, now time.Time) time.Duration
+	// Fail ends a reserved attempt as failed and returns the wait before the
+	// next one.
+	Fail(key string, now time.Time) time.Duration
+	// Release ends a reserved attempt that did not fail.
+	Release(key string)
+	// Reset forgets the failures of key, its reserved attempts still have to
+	// be ended.
+	Reset(key string)
+}
+
+type state struct {
+	failures      int
+	last_failure  time.Time
+	blocked_until time.Time
+	in_flight     int
+	last_reserved time.Time
+}
+
+// reservations not ended by then are forgotten, so an attempt that never
+// called Fail or Release does not hold its key forever
+const reservation_timeout time.Duration = time.Minute
+
+type memoryAttemptStore struct {
+	mutex  sync.Mutex
+	policy Policy
+	states map[string]*state
+}
+
+const prune_threshold int = 10000
+
+func NewMemoryAttemptStore(policy Policy) IAttemptStore {
+	return &memoryAttemptStore{
+		policy: policy,
+		states: map[string]*state{},
+	}
+}
+
+func (store *memoryAttemptStore) get(key string, now time.Time) *state {
+	current, ok := store.states[key]
+	if !ok {
+		return nil
+	}
+
+	if current.in_flight > 0 && now.Sub(current.last_reserved) > reservation_timeout {
+		current.in_flight = 0
+	}
+
+	// an expired lockout starts over instead of re-locking on the next failure
+	expired_lockout := current.failures >= store.policy.LockoutAfter && !now.Before(current.blocked_until)
+	if expired_lockout || (now.Sub(current.last_failure) > store.policy.Window && !now.Before(current.blocked_until)) {
+		if current.in_flight > 0 {
+			current.failures = 0
+
+			return current
+		}
+
+		delete(store.states, key)
+
+		return nil
+	}
+
+	return current
+}
+
+// delay returns how long a key must wait after failures failures.
+func (store *memoryAttemptStore) delay(failures int) time.Duration {
+	policy := store.policy
+
+	switch {
+	case failures >= policy.LockoutAfter:
+		return policy.LockoutDuration
+	case failures > policy.FreeAttempts:
+		delay := policy.BaseDelay << (failures - policy.FreeAttempts - 1)
+		if delay <= 0 || delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+
+		return delay
+	}
+
+	return 0
+}
+
+func (store *memoryAttemptStore) Check(key string, now time.Time) time.Duration {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.prune(now)
+
+	current := store.get(key, now)
+	if current == nil {
+		current = &state{}
+		store.states[key] = current
+	}
+
+	if now.Before(current.blocked_until) {
+		return current.blocked_until.Sub(now)
+	}
+
+	// were the attempts in flight to fail, this one would have to wait
+	if current.in_flight > 0 {
+		delay := store.delay(current.failures + current.in_flight)
+		if delay > 0 {
+			return delay
+		}
+	}
+
+	current.in_flight++
+	current.last_reserved = now
+
+	return 0
+}
+
+func (store *memoryAttemptStore) Fail(key string, now time.Time) time.Duration {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.prune(now)
+
+	current := store.get(key, now)
+	if current == nil {
+		current = &state{}
+		store.states[key] = current
+	}
+
+	if current.in_flight > 0 {
+		current.in_flight--
+	}
+
+	current.failures++
+	current.last_failure = now
+
+	delay := store.delay(current.failures)
+	if delay > 0 {
+		current.blocked_until = now.Add(delay)
+	}
+
+	if !now.Before(current.blocked_until) {
+		return 0
+	}
+
+	return current.blocked_until.Sub(now)
+}
+
+func (store *memoryAttemptStore) Release(key string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, ok := store.states[key]
+	if !ok {
+		return
+	}
+
+	if current.in_flight > 0 {
+		current.in_flight--
+	}
+
+	if current.in_flight == 0 && current.failures == 0 {
+		delete(store.states, key)
+	}
+}
+
+func (store *memoryAttemptStore) Reset(key string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, ok := store.states[key]
+	if !ok {
+		return
+	}
+
+	if current.in_flight == 0 {
+		delete(store.states, key)
+
+		return
+	}
+
+	current.failures = 0
+	current.blocked_until = time.Time{}
+}
+
+// prune drops the forgotten states once there are many of them.
+func (store *memoryAttemptStore) prune(now time.Time) {
+	if len(store.states) < prune_threshold {
+		return
+	}
+
+	for stored_key := range store.states {
+		store.get(stored_key, now)
+	}
+}
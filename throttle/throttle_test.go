@@ -0,0 +1,174 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var test_policy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	LockoutAfter:    7,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// fail reserves an attempt of key at now and fails it, returning the wait
+// Fail asks for.
+func fail(t *testing.T, store IAttemptStore, key string, now time.Time) time.Duration {
+	t.Helper()
+
+	assert.Zero(t, store.Check(key, now), "attempt at %s", now)
+
+	return store.Fail(key, now)
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	// free, free, then doubling from BaseDelay up to MaxDelay, then lockout
+	for i, expected := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 15 * time.Minute} {
+		delay := fail(t, store, "key", now)
+		assert.Equal(t, expected, delay, "failure %d", i+1)
+
+		if delay > 0 {
+			assert.Equal(t, delay, store.Check("key", now))
+			assert.Equal(t, time.Millisecond, store.Check("key", now.Add(delay-time.Millisecond)))
+		}
+
+		now = now.Add(delay)
+	}
+
+	// other keys are not slowed down
+	assert.Zero(t, store.Check("other", now))
+}
+
+func TestLockout(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	for i := 0; i < test_policy.LockoutAfter; i++ {
+		now = now.Add(fail(t, store, "key", now))
+	}
+
+	locked_at := now.Add(-test_policy.LockoutDuration)
+	assert.Equal(t, time.Minute, store.Check("key", locked_at.Add(14*time.Minute)))
+
+	// an expired lockout starts over with free attempts
+	now = locked_at.Add(test_policy.LockoutDuration)
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Equal(t, time.Second, fail(t, store, "key", now))
+}
+
+func TestWindow(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		fail(t, store, "key", now)
+	}
+
+	// failures are forgotten a Window after the last of them
+	now = now.Add(test_policy.Window + time.Second)
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Equal(t, time.Second, fail(t, store, "key", now))
+}
+
+func TestRelease(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	fail(t, store, "key", now)
+	fail(t, store, "key", now)
+
+	// a successful attempt ends its reservation without forgiving failures
+	for i := 0; i < 5; i++ {
+		assert.Zero(t, store.Check("key", now))
+		store.Release("key")
+	}
+
+	assert.Equal(t, time.Second, fail(t, store, "key", now))
+
+	// releasing what was never reserved changes nothing
+	store.Release("key")
+	store.Release("other")
+	assert.Equal(t, time.Second, store.Check("key", now))
+}
+
+func TestReset(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	for i := 0; i < test_policy.LockoutAfter; i++ {
+		now = now.Add(fail(t, store, "key", now))
+	}
+
+	now = now.Add(-test_policy.LockoutDuration)
+	assert.NotZero(t, store.Check("key", now))
+
+	store.Reset("key")
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Zero(t, fail(t, store, "key", now))
+	assert.Equal(t, time.Second, fail(t, store, "key", now))
+
+	// the reservations in flight outlive Reset
+	store.Reset("key")
+	assert.Zero(t, store.Check("key", now))
+	assert.Zero(t, store.Check("key", now))
+	assert.Zero(t, store.Check("key", now))
+	store.Reset("key")
+	assert.Equal(t, time.Second, store.Check("key", now))
+}
+
+// TestConcurrentCheck makes sure a burst of parallel attempts cannot all
+// pass Check before the first of them fails: only as many pass as could
+// fail without a delay.
+func TestConcurrentCheck(t *testing.T) {
+	store := NewMemoryAttemptStore(test_policy)
+	now := time.Now()
+
+	var wait_group sync.WaitGroup
+	var mutex sync.Mutex
+	reserved := 0
+
+	for i := 0; i < 50; i++ {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+
+			if store.Check("key", now) == 0 {
+				mutex.Lock()
+				reserved++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wait_group.Wait()
+
+	assert.Equal(t, test_policy.FreeAttempts+1, reserved)
+
+	// were they all to fail, the next attempt would wait
+	assert.Equal(t, time.Second, store.Check("key", now))
+
+	for i := 0; i < reserved; i++ {
+		store.Release("key")
+	}
+
+	assert.Zero(t, store.Check("key", now))
+	store.Release("key")
+
+	// a reservation never ended is forgotten after reservation_timeout
+	for i := 0; i < test_policy.FreeAttempts+1; i++ {
+		assert.Zero(t, store.Check("key", now))
+	}
+
+	assert.NotZero(t, store.Check("key", now))
+	assert.Zero(t, store.Check("key", now.Add(reservation_timeout+time.Second)))
+}
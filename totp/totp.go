@@ -0,0 +1,217 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const Period int64 = 30
+const Digits int = 6
+
+// skew is how many periods before and after the current one are accepted to
+// cover clock drift between the server and the authenticator app.
+const skew int64 = 1
+
+var ErrNoEncryptionKey = errors.New("totp: TOTP_ENCRYPTION_KEY is not set")
+var errInvalidCiphertext = errors.New("totp: invalid encrypted secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() (string, error) {
+	buffer := make([]byte, 20)
+
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(buffer), nil
+}
+
+// ProvisioningUri returns the otpauth:// uri authenticator apps read from a
+// QR code.
+func ProvisioningUri(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code computes the RFC 6238 code of secret for the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+func Step(now time.Time) int64 {
+	return now.Unix() / Period
+}
+
+// Validate checks code against the steps around now. Steps up to and
+// including last_step were already used and are refused, so a code cannot be
+// replayed. On success the matching step is returned, to be stored as the
+// new last_step.
+func Validate(secret, code string, now time.Time, last_step int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(now)
+	for step := current - skew; step <= current+skew; step++ {
+		if step <= last_step {
+			continue
+		}
+
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func encryptionKey() ([]byte, error) {
+	secret := os.Getenv("TOTP_ENCRYPTION_KEY")
+	if secret == "" {
+		return nil, ErrNoEncryptionKey
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+
+	return sum[:], nil
+}
+
+// EncryptSecret seals secret with AES-GCM under TOTP_ENCRYPTION_KEY so a
+// database dump alone is not enough to generate codes.
+func EncryptSecret(secret string) (string, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptSecret(encrypted string) (string, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errInvalidCiphertext
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// GenerateRecoveryCodes returns n random single-use codes formatted as
+// xxxxx-xxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	code_encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < n; i++ {
+		buffer := make([]byte, 7)
+
+		_, err := rand.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(code_encoding.EncodeToString(buffer))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes user input comparable with generated codes.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc_secret is the SHA1 seed of RFC 6238 appendix B, "12345678901234567890"
+// in base32.
+const rfc_secret string = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRfc6238 checks the SHA1 vectors of RFC 6238 appendix B. They are
+// 8 digits long, a 6 digit code is their last 6 digits.
+func TestCodeRfc6238(t *testing.T) {
+	for _, test := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		t.Run(test.code, func(t *testing.T) {
+			code, err := Code(rfc_secret, Step(time.Unix(test.unix, 0)))
+			require.NoError(t, err)
+			assert.Equal(t, test.code[len(test.code)-Digits:], code)
+
+			// lower case secrets are what some apps show
+			code, err = Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(test.unix, 0)))
+			require.NoError(t, err)
+			assert.Equal(t, test.code[len(test.code)-Digits:], code)
+		})
+	}
+
+	_, err := Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		code, err := Code(rfc_secret, step)
+		require.NoError(t, err)
+
+		return code
+	}
+
+	for _, test := range []struct {
+		name      string
+		code      string
+		last_step int64
+		step      int64
+		ok        bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"with spaces", " " + code(current)[:3] + " " + code(current)[3:], 0, current, true},
+		{"previous step", code(current - 1), 0, current - 1, true},
+		{"next step", code(current + 1), 0, current + 1, true},
+		{"beyond skew", code(current - 2), 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", code(current)[1:], 0, 0, false},
+		// a code of the last used step, or an older one, is a replay
+		{"last used step", code(current), current, 0, false},
+		{"before last used step", code(current - 1), current, 0, false},
+		{"after last used step", code(current + 1), current, current + 1, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := Validate(rfc_secret, test.code, now, test.last_step)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.step, step)
+		})
+	}
+}